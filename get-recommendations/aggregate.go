@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"math"
+
+	"get-recommendations/collector"
+)
+
+// allContainers is reported as the container name for rows summed across every container of a workload
+const allContainers = "ALL"
+
+// sumContainers collapses the results into one row per workload (VPA), summing the recommendations, current requests
+// and diffs of its containers. This matches how pods are sized for scheduling. The order of the workloads is preserved.
+func sumContainers(results []collector.ContainerConfig) []collector.ContainerConfig {
+	summed := sumBy(results, func(r collector.ContainerConfig) string {
+		return fmt.Sprintf("%s/%s", r.Namespace, r.VPAName)
+	})
+	for i := range summed {
+		summed[i].ContainerName = allContainers
+	}
+
+	return summed
+}
+
+// sumBy collapses the results sharing the same key into one row, summing their recommendations, current requests and diffs.
+// The other fields are taken from the first row with the key, whose position is preserved.
+func sumBy(results []collector.ContainerConfig, key func(collector.ContainerConfig) string) []collector.ContainerConfig {
+	summed := make([]collector.ContainerConfig, 0)
+	index := make(map[string]int)
+
+	for _, r := range results {
+		k := key(r)
+		i, found := index[k]
+		if !found {
+			index[k] = len(summed)
+			s := r
+			s.CPUTrend, s.MemTrend = "", ""
+			summed = append(summed, s)
+			continue
+		}
+
+		s := &summed[i]
+		s.TargetCPU += r.TargetCPU
+		s.TargetMemory += r.TargetMemory
+		s.UpperCPU += r.UpperCPU
+		s.UpperMemory += r.UpperMemory
+		s.LowerCPU += r.LowerCPU
+		s.LowerMemory += r.LowerMemory
+		s.CappedCPU += r.CappedCPU
+		s.CappedMemory += r.CappedMemory
+		s.CurrentConfig.CurrentCPU += r.CurrentConfig.CurrentCPU
+		s.CurrentConfig.CurrentMem += r.CurrentConfig.CurrentMem
+		s.CurrentConfig.RequestCPU += r.CurrentConfig.RequestCPU
+		s.CurrentConfig.RequestMem += r.CurrentConfig.RequestMem
+		s.CurrentConfig.LimitCPU += r.CurrentConfig.LimitCPU
+		s.CurrentConfig.LimitMem += r.CurrentConfig.LimitMem
+		s.CurrentConfig.CPUDiff += r.CurrentConfig.CPUDiff
+		s.CurrentConfig.MemDiff += r.CurrentConfig.MemDiff
+		s.CurrentConfig.CappedCPUDiff += r.CurrentConfig.CappedCPUDiff
+		s.CurrentConfig.CappedMemDiff += r.CurrentConfig.CappedMemDiff
+
+		// A partial sum would be misleading, so PENDING if any of the containers are missing a recommendation
+		if r.TargetCPUStr == collector.Pending {
+			s.TargetCPUStr = collector.Pending
+		}
+		if r.TargetMemoryStr == collector.Pending {
+			s.TargetMemoryStr = collector.Pending
+		}
+		if r.UpperCPUStr == collector.Pending {
+			s.UpperCPUStr = collector.Pending
+		}
+		if r.UpperMemoryStr == collector.Pending {
+			s.UpperMemoryStr = collector.Pending
+		}
+		if r.CappedCPUStr == collector.Pending {
+			s.CappedCPUStr = collector.Pending
+		}
+		if r.CappedMemoryStr == collector.Pending {
+			s.CappedMemoryStr = collector.Pending
+		}
+
+		// Only NOT_CONTROLLED when none of the containers are controlled, otherwise PENDING
+		s.CPUNotControlled = s.CPUNotControlled && r.CPUNotControlled
+		s.MemNotControlled = s.MemNotControlled && r.MemNotControlled
+
+		// Only NOT_SET when none of the containers have a request
+		if s.CurrentConfig.CurrentCPUStr != r.CurrentConfig.CurrentCPUStr && s.CurrentConfig.CurrentCPUStr == collector.NotSet {
+			s.CurrentConfig.CurrentCPUStr = ""
+		}
+		if s.CurrentConfig.CurrentMemStr != r.CurrentConfig.CurrentMemStr && s.CurrentConfig.CurrentMemStr == collector.NotSet {
+			s.CurrentConfig.CurrentMemStr = ""
+		}
+		if s.CurrentConfig.CPUBasis != r.CurrentConfig.CPUBasis {
+			s.CurrentConfig.CPUBasis = compareMixed
+		}
+		if s.CurrentConfig.MemBasis != r.CurrentConfig.MemBasis {
+			s.CurrentConfig.MemBasis = compareMixed
+		}
+		if s.CurrentConfig.ContainerType != r.CurrentConfig.ContainerType {
+			s.CurrentConfig.ContainerType = compareMixed
+		}
+		if s.VPAManagedBy != r.VPAManagedBy {
+			s.VPAManagedBy = compareMixed
+		}
+		if s.CPUMultiplier != r.CPUMultiplier {
+			s.CPUMultiplier = compareMixed
+		}
+		if s.MemoryMultiplier != r.MemoryMultiplier {
+			s.MemoryMultiplier = compareMixed
+		}
+	}
+
+	// Re-format the summed values in the same K8s units as the per-container rows
+	for i := range summed {
+		formatSums(&summed[i])
+	}
+
+	return summed
+}
+
+// formatSums re-formats the recommendations and current requests of the result from their raw values, e.g. once summed.
+// PENDING and NOT_SET are left as they are.
+func formatSums(s *collector.ContainerConfig) {
+	if s.TargetCPUStr != collector.Pending {
+		s.TargetCPUStr = fmt.Sprintf("%dm", s.TargetCPU)
+	}
+	if s.TargetMemoryStr != collector.Pending {
+		s.TargetMemoryStr = collector.FormatMemory(s.TargetMemory)
+	}
+	if s.UpperCPUStr != collector.Pending {
+		s.UpperCPUStr = fmt.Sprintf("%dm", s.UpperCPU)
+	}
+	if s.UpperMemoryStr != collector.Pending {
+		s.UpperMemoryStr = collector.FormatMemory(s.UpperMemory)
+	}
+	if s.CappedCPUStr != collector.Pending {
+		s.CappedCPUStr = fmt.Sprintf("%dm", s.CappedCPU)
+	}
+	if s.CappedMemoryStr != collector.Pending {
+		s.CappedMemoryStr = collector.FormatMemory(s.CappedMemory)
+	}
+	if s.CurrentConfig.CurrentCPUStr != collector.NotSet {
+		s.CurrentConfig.CurrentCPUStr = fmt.Sprintf("%dm", s.CurrentConfig.CurrentCPU)
+	}
+	if s.CurrentConfig.CurrentMemStr != collector.NotSet {
+		s.CurrentConfig.CurrentMemStr = collector.FormatMemory(s.CurrentConfig.CurrentMem)
+	}
+}
+
+// scaleResult multiplies the raw recommendations, current requests and diffs of the result by mul and divides them by div,
+// rounding to the nearest unit. The formatted values aren't updated, see formatSums.
+func scaleResult(r *collector.ContainerConfig, mul, div int64) {
+	scale := func(v int64) int64 {
+		return int64(math.Round(float64(v) * float64(mul) / float64(div)))
+	}
+
+	r.TargetCPU, r.TargetMemory = scale(r.TargetCPU), scale(r.TargetMemory)
+	r.UpperCPU, r.UpperMemory = scale(r.UpperCPU), scale(r.UpperMemory)
+	r.LowerCPU, r.LowerMemory = scale(r.LowerCPU), scale(r.LowerMemory)
+	r.CappedCPU, r.CappedMemory = scale(r.CappedCPU), scale(r.CappedMemory)
+
+	d := &r.CurrentConfig
+	d.CurrentCPU, d.CurrentMem = scale(d.CurrentCPU), scale(d.CurrentMem)
+	d.RequestCPU, d.RequestMem = scale(d.RequestCPU), scale(d.RequestMem)
+	d.LimitCPU, d.LimitMem = scale(d.LimitCPU), scale(d.LimitMem)
+	d.CPUDiff, d.MemDiff = scale(d.CPUDiff), scale(d.MemDiff)
+	d.CappedCPUDiff, d.CappedMemDiff = scale(d.CappedCPUDiff), scale(d.CappedMemDiff)
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	"get-recommendations/collector"
+)
+
+func TestSumContainersMissingMemory(t *testing.T) {
+	results := []collector.ContainerConfig{
+		{Namespace: "ns", VPAName: "app", ContainerName: "app", TargetCPUStr: "100m", TargetCPU: 100, TargetMemoryStr: "128Mi", TargetMemory: 128 * 1024 * 1024},
+		{Namespace: "ns", VPAName: "app", ContainerName: "sidecar", TargetCPUStr: "50m", TargetCPU: 50, TargetMemoryStr: collector.Pending},
+	}
+
+	summed := sumContainers(results)
+	if len(summed) != 1 {
+		t.Fatalf("sumContainers() returned %d rows, want 1", len(summed))
+	}
+	if summed[0].TargetCPUStr != "150m" {
+		t.Errorf("targetCPUStr = %q, want \"150m\"", summed[0].TargetCPUStr)
+	}
+	if summed[0].TargetMemoryStr != collector.Pending {
+		t.Errorf("targetMemoryStr = %q, want %q", summed[0].TargetMemoryStr, collector.Pending)
+	}
+}
+
+func TestSumContainersMemoryScales(t *testing.T) {
+	results := make([]collector.ContainerConfig, 0)
+	for i, memory := range []string{"131072Ki", "128Mi", "0.125Gi"} {
+		mem, bytes := collector.RecommendedMemory(v1.ResourceList{v1.ResourceMemory: resource.MustParse(memory)})
+		results = append(results, collector.ContainerConfig{Namespace: "ns", VPAName: "app", ContainerName: fmt.Sprintf("c%d", i), TargetCPUStr: "10m", TargetCPU: 10, TargetMemoryStr: mem, TargetMemory: bytes})
+	}
+
+	summed := sumContainers(results)
+	if summed[0].TargetMemoryStr != "384Mi" || summed[0].TargetMemory != 384*collector.Mebibyte {
+		t.Errorf("summed memory = %q, %d, want \"384Mi\", %d", summed[0].TargetMemoryStr, summed[0].TargetMemory, 384*collector.Mebibyte)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+
+	"get-recommendations/collector"
+)
+
+// Annotations recording the latest recommended targets on each workload, as comma separated container=value pairs
+const (
+	cpuAnnotation    = "vpa-recommendations/cpu"
+	memoryAnnotation = "vpa-recommendations/memory"
+)
+
+// annotateWorkloads patches each supported workload's annotations with its container recommendations, so they're visible in
+// kubectl describe. Patching is idempotent, as the values only change when the recommendations do.
+func annotateWorkloads(ctx context.Context, client *kubernetes.Clientset, results []collector.ContainerConfig, l *slog.Logger) error {
+	type workloadKey struct{ namespace, resourceType, resourceName string }
+	cpu := make(map[workloadKey][]string)
+	memory := make(map[workloadKey][]string)
+	order := make([]workloadKey, 0)
+
+	for _, r := range results {
+		// Placeholder rows for VPAs without any recommendations yet
+		if r.TargetCPUStr == collector.Pending && r.TargetMemoryStr == collector.Pending {
+			continue
+		}
+		k := workloadKey{r.Namespace, r.ResourceType, r.ResourceName}
+		if _, found := cpu[k]; !found {
+			order = append(order, k)
+		}
+		cpu[k] = append(cpu[k], fmt.Sprintf("%s=%s", r.ContainerName, r.TargetCPUStr))
+		memory[k] = append(memory[k], fmt.Sprintf("%s=%s", r.ContainerName, r.TargetMemoryStr))
+	}
+
+	for _, k := range order {
+		patch, err := json.Marshal(map[string]any{
+			"metadata": map[string]any{
+				"annotations": map[string]string{
+					cpuAnnotation:    strings.Join(cpu[k], ","),
+					memoryAnnotation: strings.Join(memory[k], ","),
+				},
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("building annotation patch: %w", err)
+		}
+
+		op := fmt.Sprintf("annotating %s %s/%s", k.resourceType, k.namespace, k.resourceName)
+		switch k.resourceType {
+		case "Deployment":
+			_, err = client.AppsV1().Deployments(k.namespace).Patch(ctx, k.resourceName, types.MergePatchType, patch, metav1.PatchOptions{})
+		case "StatefulSet":
+			_, err = client.AppsV1().StatefulSets(k.namespace).Patch(ctx, k.resourceName, types.MergePatchType, patch, metav1.PatchOptions{})
+		case "DaemonSet":
+			_, err = client.AppsV1().DaemonSets(k.namespace).Patch(ctx, k.resourceName, types.MergePatchType, patch, metav1.PatchOptions{})
+		default:
+			l.Debug("target kind not supported. Not annotating", "namespace", k.namespace, "resourceType", k.resourceType, "resourceName", k.resourceName)
+			continue
+		}
+		if err != nil {
+			return &collector.APIError{Op: op, Err: err}
+		}
+		l.Debug("Annotated workload with recommendations", "namespace", k.namespace, "resourceType", k.resourceType, "resourceName", k.resourceName)
+	}
+
+	return nil
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+
+	"get-recommendations/collector"
+)
+
+// withoutHPACPU returns a copy of the results with the CPU recommendation dropped for workloads whose HPA scales on CPU.
+// Changing the CPU request would shift the utilisation the HPA scales on, so those are left for a human to decide.
+func withoutHPACPU(results []collector.ContainerConfig, l *slog.Logger) []collector.ContainerConfig {
+	filtered := make([]collector.ContainerConfig, 0, len(results))
+	for _, r := range results {
+		if r.HPAScalesOnCPU && r.TargetCPUStr != collector.Pending {
+			l.Warn("Workload has an HPA scaling on CPU. Not applying the CPU recommendation", "namespace", r.Namespace, "resourceType", r.ResourceType, "resourceName", r.ResourceName, "container", r.ContainerName)
+			r.TargetCPUStr, r.TargetCPU = collector.Pending, 0
+		}
+		filtered = append(filtered, r)
+	}
+
+	return filtered
+}
+
+// confirmApply asks on out whether to patch the workloads, returning true if the answer read from in is yes.
+func confirmApply(in io.Reader, out io.Writer, patches []workloadPatch) (bool, error) {
+	fmt.Fprintf(out, "About to patch the requests of %d workloads:\n", len(patches))
+	for _, p := range patches {
+		fmt.Fprintf(out, "  %s/%s/%s\n", p.Namespace, p.ResourceType, p.ResourceName)
+	}
+	fmt.Fprint(out, "Continue? [y/N] ")
+
+	answer, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return false, fmt.Errorf("reading confirmation: %w", err)
+	}
+
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes", nil
+}
+
+// dryRunReportFile lists the changes -apply -dry-run would make to each container, and whether they were accepted
+const dryRunReportFile = "apply-dry-run.csv"
+
+// dryRunChange is the change to the requests of a container validated by -apply -dry-run.
+type dryRunChange struct {
+	namespace     string
+	resourceType  string
+	resourceName  string
+	containerName string
+	oldCPU        string
+	newCPU        string
+	oldMemory     string
+	newMemory     string
+	rejected      string // reason the API server or an admission webhook rejected the patch, empty if accepted
+}
+
+// patchWorkload patches the workload's containers with a strategic merge patch, which merges the containers by name so only
+// the matched containers' resources change. Returns the patched pod template, as it would be persisted after admission.
+func patchWorkload(ctx context.Context, client kubernetes.Interface, p workloadPatch, opts metav1.PatchOptions) (v1.PodSpec, error) {
+	data, err := json.Marshal(p.Patch)
+	if err != nil {
+		return v1.PodSpec{}, fmt.Errorf("encoding patch: %w", err)
+	}
+
+	switch p.ResourceType {
+	case "Deployment":
+		d, err := client.AppsV1().Deployments(p.Namespace).Patch(ctx, p.ResourceName, types.StrategicMergePatchType, data, opts)
+		if err != nil {
+			return v1.PodSpec{}, err
+		}
+		return d.Spec.Template.Spec, nil
+	case "StatefulSet":
+		s, err := client.AppsV1().StatefulSets(p.Namespace).Patch(ctx, p.ResourceName, types.StrategicMergePatchType, data, opts)
+		if err != nil {
+			return v1.PodSpec{}, err
+		}
+		return s.Spec.Template.Spec, nil
+	case "DaemonSet":
+		d, err := client.AppsV1().DaemonSets(p.Namespace).Patch(ctx, p.ResourceName, types.StrategicMergePatchType, data, opts)
+		if err != nil {
+			return v1.PodSpec{}, err
+		}
+		return d.Spec.Template.Spec, nil
+	default:
+		return v1.PodSpec{}, fmt.Errorf("patching %s: %w", p.ResourceType, collector.ErrUnsupportedKind)
+	}
+}
+
+// applyPatches patches the requests of each workload's containers, stopping at the first failure.
+func applyPatches(ctx context.Context, client kubernetes.Interface, patches []workloadPatch, l *slog.Logger) error {
+	for _, p := range patches {
+		_, err := patchWorkload(ctx, client, p, metav1.PatchOptions{})
+		if errors.Is(err, collector.ErrUnsupportedKind) {
+			l.Debug("target kind not supported. Not patching", "namespace", p.Namespace, "resourceType", p.ResourceType, "resourceName", p.ResourceName)
+			continue
+		}
+		if err != nil {
+			return &collector.APIError{Op: fmt.Sprintf("patching %s %s/%s", p.ResourceType, p.Namespace, p.ResourceName), Err: err}
+		}
+		l.Info("Applied recommendations", "namespace", p.Namespace, "resourceType", p.ResourceType, "resourceName", p.ResourceName)
+	}
+
+	return nil
+}
+
+// dryRunPatches validates each patch via a server-side dry run, which runs the admission webhooks without persisting anything.
+// Returns the old and new requests of each patched container. A patch rejected by the API server or a webhook is recorded
+// against its containers rather than stopping the run, whereas any other failure, such as a connection error, is returned.
+func dryRunPatches(ctx context.Context, client kubernetes.Interface, patches []workloadPatch, results []collector.ContainerConfig, l *slog.Logger) ([]dryRunChange, error) {
+	// Keyed by the matched container's name, which the patches use
+	current := make(map[string]collector.ResourceDrift, len(results))
+	for _, r := range results {
+		current[fmt.Sprintf("%s/%s/%s/%s", r.Namespace, r.ResourceType, r.ResourceName, r.CurrentConfig.ContainerName)] = r.CurrentConfig
+	}
+
+	changes := make([]dryRunChange, 0)
+	for _, p := range patches {
+		spec, err := patchWorkload(ctx, client, p, metav1.PatchOptions{DryRun: []string{metav1.DryRunAll}})
+		if errors.Is(err, collector.ErrUnsupportedKind) {
+			l.Debug("target kind not supported. Not patching", "namespace", p.Namespace, "resourceType", p.ResourceType, "resourceName", p.ResourceName)
+			continue
+		}
+
+		var rejected string
+		var status k8serrors.APIStatus
+		switch {
+		case errors.As(err, &status):
+			rejected = status.Status().Message
+			l.Warn("Patch rejected by the dry run", "namespace", p.Namespace, "resourceType", p.ResourceType, "resourceName", p.ResourceName, "reason", rejected)
+		case err != nil:
+			return nil, &collector.APIError{Op: fmt.Sprintf("dry run patching %s %s/%s", p.ResourceType, p.Namespace, p.ResourceName), Err: err}
+		default:
+			l.Info("Patch accepted by the dry run", "namespace", p.Namespace, "resourceType", p.ResourceType, "resourceName", p.ResourceName)
+		}
+
+		// Keyed by lower cased name, keeping the first of any duplicates as when matching the recommendations
+		patched := make(map[string]v1.Container, len(spec.Containers))
+		for _, c := range spec.Containers {
+			if _, found := patched[strings.ToLower(c.Name)]; !found {
+				patched[strings.ToLower(c.Name)] = c
+			}
+		}
+		for _, c := range p.Patch.Spec.Template.Spec.Containers {
+			old := current[fmt.Sprintf("%s/%s/%s/%s", p.Namespace, p.ResourceType, p.ResourceName, c.Name)]
+			change := dryRunChange{
+				namespace:     p.Namespace,
+				resourceType:  p.ResourceType,
+				resourceName:  p.ResourceName,
+				containerName: c.Name,
+				oldCPU:        formatRequest(old.RequestCPU, fmt.Sprintf("%dm", old.RequestCPU)),
+				oldMemory:     formatRequest(old.RequestMem, collector.FormatMemory(old.RequestMem)),
+				newCPU:        c.Resources.Requests["cpu"],
+				newMemory:     c.Resources.Requests["memory"],
+				rejected:      rejected,
+			}
+
+			// Report what would be persisted, which a mutating webhook or LimitRange may have changed from the patch
+			if container, found := patched[strings.ToLower(c.Name)]; found && rejected == "" {
+				if q, found := container.Resources.Requests[v1.ResourceCPU]; found {
+					change.newCPU = fmt.Sprintf("%dm", q.MilliValue())
+				}
+				if q, found := container.Resources.Requests[v1.ResourceMemory]; found {
+					change.newMemory = collector.FormatMemory(q.Value())
+				}
+			}
+			changes = append(changes, change)
+		}
+	}
+
+	return changes, nil
+}
+
+// formatRequest returns the formatted request, or NOT_SET if the request is zero.
+func formatRequest(value int64, formatted string) string {
+	if value == 0 {
+		return collector.NotSet
+	}
+
+	return formatted
+}
+
+// writeDryRunReport writes a row per container to dryRunReportFile, showing its requests before and after the patch.
+func writeDryRunReport(changes []dryRunChange) error {
+	f, err := os.Create(dryRunReportFile)
+	if err != nil {
+		return fmt.Errorf("creating dry run report file: %w", err)
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	if err := w.Write([]string{"namespace", "resourceType", "resourceName", "containerName", "currentCPU", "newCPU", "currentMemory", "newMemory", "accepted", "rejectionReason"}); err != nil {
+		return fmt.Errorf("writing dry run report to csv: %w", err)
+	}
+	for _, c := range changes {
+		if err := w.Write([]string{c.namespace, c.resourceType, c.resourceName, c.containerName, c.oldCPU, c.newCPU, c.oldMemory, c.newMemory, strconv.FormatBool(c.rejected == ""), c.rejected}); err != nil {
+			return fmt.Errorf("writing dry run report to csv: %w", err)
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return fmt.Errorf("flushing csv writer: %w", err)
+	}
+
+	return nil
+}
+
+// applyRecommendations patches the workloads to the recommendations, asking for confirmation on stdin unless -yes is set.
+// With -dry-run nothing is changed. Instead the patches are validated, writing the old and new requests to dryRunReportFile.
+func applyRecommendations(ctx context.Context, client kubernetes.Interface, results []collector.ContainerConfig, opts options, l *slog.Logger) error {
+	patches := buildPatches(withoutHPACPU(results, l), opts.patchMode, l)
+	if len(patches) == 0 {
+		l.Info("No recommendations to apply")
+		return nil
+	}
+
+	if opts.dryRun {
+		changes, err := dryRunPatches(ctx, client, patches, results, l)
+		if err != nil {
+			return err
+		}
+		rejected := 0
+		for _, c := range changes {
+			if c.rejected != "" {
+				rejected++
+			}
+		}
+		l.Info("Dry run complete. Nothing was changed", "containers", len(changes), "rejected", rejected, "report", dryRunReportFile)
+		return writeDryRunReport(changes)
+	}
+
+	if !opts.assumeYes {
+		confirmed, err := confirmApply(os.Stdin, os.Stderr, patches)
+		if err != nil {
+			return err
+		}
+		if !confirmed {
+			l.Info("Not applying recommendations")
+			return nil
+		}
+	}
+
+	return applyPatches(ctx, client, patches, l)
+}
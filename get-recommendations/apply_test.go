@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	"get-recommendations/collector"
+)
+
+func TestDryRunPatchesCaseMismatchedContainer(t *testing.T) {
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "team", Name: "api"},
+		Spec: appsv1.DeploymentSpec{
+			Template: v1.PodTemplateSpec{Spec: v1.PodSpec{Containers: []v1.Container{{
+				Name:  "App",
+				Image: "api:1.0",
+				Resources: v1.ResourceRequirements{Requests: v1.ResourceList{
+					v1.ResourceCPU:    resource.MustParse("100m"),
+					v1.ResourceMemory: resource.MustParse("128Mi"),
+				}},
+			}}}},
+		},
+	}
+	client := fake.NewSimpleClientset(deployment)
+
+	var sent []byte
+	client.PrependReactor("patch", "deployments", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		sent = action.(k8stesting.PatchAction).GetPatch()
+		return false, nil, nil
+	})
+
+	// The VPA spells the container in lower case, and was matched to the template's "App"
+	results := []collector.ContainerConfig{
+		{
+			Namespace:       "team",
+			ResourceType:    "Deployment",
+			ResourceName:    "api",
+			ContainerName:   "app",
+			TargetCPUStr:    "250m",
+			TargetCPU:       250,
+			TargetMemoryStr: "256Mi",
+			TargetMemory:    256 * collector.Mebibyte,
+			CurrentConfig:   collector.ResourceDrift{ContainerType: collector.ContainerRegular, ContainerName: "App", RequestCPU: 100, RequestMem: 128 * collector.Mebibyte},
+		},
+	}
+	l := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	changes, err := dryRunPatches(context.Background(), client, buildPatches(results, patchRequests, l), results, l)
+	if err != nil {
+		t.Fatalf("dryRunPatches() error = %v", err)
+	}
+
+	var patch specPatch
+	if err := json.Unmarshal(sent, &patch); err != nil {
+		t.Fatalf("decoding the patch sent %q: %v", sent, err)
+	}
+	containers := patch.Spec.Template.Spec.Containers
+	if len(containers) != 1 || containers[0].Name != "App" {
+		t.Fatalf("patch sent = %s, want a single container named App", sent)
+	}
+
+	if len(changes) != 1 {
+		t.Fatalf("dryRunPatches() returned %d changes, want 1", len(changes))
+	}
+	c := changes[0]
+	if c.containerName != "App" || c.oldCPU != "100m" || c.newCPU != "250m" || c.oldMemory != "128Mi" || c.newMemory != "256Mi" || c.rejected != "" {
+		t.Errorf("change = %+v, want App from 100m/128Mi to 250m/256Mi, accepted", c)
+	}
+
+	// Merged into the existing container, rather than added as a new one without an image
+	patched, err := client.AppsV1().Deployments("team").Get(context.Background(), "api", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := patched.Spec.Template.Spec.Containers; len(got) != 1 || got[0].Image != "api:1.0" {
+		t.Errorf("patched containers = %+v, want the one App container with its image", got)
+	}
+}
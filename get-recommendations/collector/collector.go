@@ -0,0 +1,567 @@
+// Package collector queries the VPAs in a cluster for their per container recommendations, alongside the current config of
+// the workloads they target. get-recommendations writes the results out, but the package can be used on its own.
+package collector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	autoscaling "k8s.io/api/autoscaling/v2"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	verticalAutoscaling "k8s.io/autoscaler/vertical-pod-autoscaler/pkg/apis/autoscaling.k8s.io/v1"
+	verticalAutoscalingClientSet "k8s.io/autoscaler/vertical-pod-autoscaler/pkg/client/clientset/versioned"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Options selects the VPAs and how their recommendations are collected, mirroring the get-recommendations flags. A zero
+// WorkersPerNamespace, CPUMultiplier or MemoryMultiplier is treated as 1.
+type Options struct {
+	Namespaces              []string
+	CompareAgainst          string
+	IncludePending          bool
+	TeamLabel               string
+	MinWorkloadAge          time.Duration
+	IncludeSystemNamespaces bool
+	IncludeTerminating      bool
+	LivePodsWhenMutated     bool
+	ClusterList             bool
+	RecommendationSource    string
+	CompareLivePods         string   // how to combine the running pods' requests, empty to compare against the template
+	ContainerDenylist       []string // containers, such as well-known sidecars, left out of the results
+	WorkersPerNamespace     int
+	OperatorLabels          []labels.Selector // workloads matching any are skipped, nil unless -skip-operator-managed is set
+	CPUMultiplier           float64
+	MemoryMultiplier        float64
+	MinCurrentCPU           int64 // millicores, zero to not filter on the current CPU
+	MinCurrentMem           int64 // bytes, zero to not filter on the current memory
+	DedupeContainers        bool
+	ResolveOwners           bool // whether to resolve the top-level controller of each workload, for -group-by=owner
+}
+
+// Collector collects a result per container recommendation of the VPAs in the targeted namespaces, leaving what is done with
+// them to the caller. get-recommendations wraps it to post-process the results and write the reports.
+type Collector struct {
+	clientset kubernetes.Interface
+	vpaClient verticalAutoscalingClientSet.Interface
+	opts      Options
+	l         *slog.Logger
+
+	// From the last call to Collect
+	namespaces []string
+	skipped    []SkippedVPA
+}
+
+// NewCollector returns a Collector querying the cluster via the clientsets, configured by opts.
+func NewCollector(clientset kubernetes.Interface, vpaClient verticalAutoscalingClientSet.Interface, opts Options, l *slog.Logger) *Collector {
+	if opts.WorkersPerNamespace < 1 {
+		opts.WorkersPerNamespace = 1
+	}
+	if opts.CPUMultiplier == 0 {
+		opts.CPUMultiplier = 1
+	}
+	if opts.MemoryMultiplier == 0 {
+		opts.MemoryMultiplier = 1
+	}
+
+	return &Collector{clientset: clientset, vpaClient: vpaClient, opts: opts, l: l}
+}
+
+// Collect returns a result per container recommendation. If no namespaces are targeted then every namespace in the cluster is
+// queried, which is re-evaluated each call. If ctx is cancelled part way, the results of the namespaces completed so far are
+// returned along with the error.
+func (c *Collector) Collect(ctx context.Context) ([]ContainerConfig, error) {
+	c.namespaces, c.skipped = c.opts.Namespaces, nil
+	if len(c.namespaces) == 0 {
+		var err error
+		c.namespaces, err = GetNamespaces(ctx, c.clientset, c.opts.IncludeSystemNamespaces, c.opts.IncludeTerminating)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	results, skipped, err := collectResults(ctx, c.clientset, c.vpaClient, c.namespaces, c.opts, c.l)
+	c.skipped = skipped
+	return results, err
+}
+
+// Namespaces returns the namespaces queried by the last call to Collect.
+func (c *Collector) Namespaces() []string {
+	return c.namespaces
+}
+
+// Skipped returns the VPAs skipped by the last call to Collect, and why.
+func (c *Collector) Skipped() []SkippedVPA {
+	return c.skipped
+}
+
+// collectResults queries the VPAs in each namespace and returns a result per container recommendation,
+// along with the VPAs which were skipped and why.
+func collectResults(ctx context.Context, clientset kubernetes.Interface, vpaClient verticalAutoscalingClientSet.Interface, namespaces []string, opts Options, l *slog.Logger) ([]ContainerConfig, []SkippedVPA, error) {
+	results := make([]ContainerConfig, 0)
+	skipped := make([]SkippedVPA, 0)
+
+	// Listing across every namespace in one call saves a round trip per namespace. Not used when targeting specific namespaces
+	var clusterVPAs map[string][]verticalAutoscaling.VerticalPodAutoscaler
+	if opts.ClusterList && len(opts.Namespaces) == 0 {
+		var err error
+		clusterVPAs, err = ListClusterVPAs(ctx, vpaClient)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	for _, namespace := range namespaces {
+		r, s, err := collectNamespace(ctx, clientset, vpaClient, namespace, clusterVPAs, opts, l)
+		if err != nil {
+			// The namespaces completed before an interruption are returned, so that they can be flushed as partial results
+			if ctx.Err() != nil {
+				return results, skipped, err
+			}
+			return nil, nil, err
+		}
+		results = append(results, r...)
+		skipped = append(skipped, s...)
+	}
+
+	return results, skipped, nil
+}
+
+// collectNamespace returns the results and skipped VPAs for a single namespace. clusterVPAs holds the VPAs of every
+// namespace when they were listed in one call, otherwise nil.
+func collectNamespace(ctx context.Context, clientset kubernetes.Interface, vpaClient verticalAutoscalingClientSet.Interface, namespace string, clusterVPAs map[string][]verticalAutoscaling.VerticalPodAutoscaler, opts Options, l *slog.Logger) ([]ContainerConfig, []SkippedVPA, error) {
+	l.Debug("Processing namespace", "namespace", namespace)
+
+	// Get HPA targets for this namespace
+	hasHPAMapping, cpuHPAMapping, err := hpaMappings(ctx, clientset, namespace)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Get the PDB selectors for this namespace, matched against the pod labels of each workload
+	pdbs, err := pdbSelectors(ctx, clientset, namespace)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	vpas := clusterVPAs[namespace]
+	if clusterVPAs == nil {
+		list, err := vpaClient.AutoscalingV1().VerticalPodAutoscalers(namespace).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return nil, nil, &APIError{Op: fmt.Sprintf("listing VPAs in %s namespace", namespace), Err: err}
+		}
+		vpas = list.Items
+	}
+	l.Debug("Found VPAs in namespace", "numVPAs", len(vpas), "namespace", namespace)
+
+	// The namespace team is used for workloads which aren't labelled themselves
+	var namespaceTeam string
+	if opts.TeamLabel != "" {
+		ns, err := clientset.CoreV1().Namespaces().Get(ctx, namespace, metav1.GetOptions{})
+		if err != nil {
+			return nil, nil, &APIError{Op: fmt.Sprintf("getting namespace %s", namespace), Err: err}
+		}
+		namespaceTeam = ns.Labels[opts.TeamLabel]
+	}
+
+	ns := namespaceInfo{
+		name:          namespace,
+		team:          namespaceTeam,
+		hasHPAMapping: hasHPAMapping,
+		cpuHPAMapping: cpuHPAMapping,
+		pdbs:          pdbs,
+	}
+
+	// Each VPA's results are kept in its own slot, so the output order doesn't depend on which worker finishes first
+	vpaResults := make([][]ContainerConfig, len(vpas))
+	vpaSkipped := make([][]SkippedVPA, len(vpas))
+	errs := make(chan error, len(vpas))
+	work := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < opts.WorkersPerNamespace; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				r, s, err := processVPA(ctx, clientset, vpas[i], ns, opts, l)
+				if err != nil {
+					errs <- err
+					continue
+				}
+				vpaResults[i], vpaSkipped[i] = r, s
+			}
+		}()
+	}
+	for i := range vpas {
+		work <- i
+	}
+	close(work)
+	wg.Wait()
+	close(errs)
+	if err := <-errs; err != nil {
+		return nil, nil, err
+	}
+
+	results := make([]ContainerConfig, 0)
+	skipped := make([]SkippedVPA, 0)
+	for i := range vpas {
+		results = append(results, vpaResults[i]...)
+		skipped = append(skipped, vpaSkipped[i]...)
+	}
+
+	return results, skipped, nil
+}
+
+// namespaceInfo holds what is looked up once per namespace and shared by the processing of each of its VPAs
+type namespaceInfo struct {
+	name          string
+	team          string // value of the -team-label label on the namespace
+	hasHPAMapping []autoscaling.CrossVersionObjectReference
+	cpuHPAMapping []autoscaling.CrossVersionObjectReference // targets of HPAs scaling on CPU
+	pdbs          []labels.Selector
+}
+
+// processVPA returns the results for each container recommendation of the VPA, or the reason it was skipped.
+// It is safe to call concurrently for the VPAs of a namespace.
+func processVPA(ctx context.Context, clientset kubernetes.Interface, vpa verticalAutoscaling.VerticalPodAutoscaler, ns namespaceInfo, opts Options, l *slog.Logger) ([]ContainerConfig, []SkippedVPA, error) {
+	results := make([]ContainerConfig, 0)
+	skipped := make([]SkippedVPA, 0)
+	namespace := ns.name
+
+	skip := func(reason string) {
+		skipped = append(skipped, SkippedVPA{
+			Namespace:    namespace,
+			VPAName:      vpa.Name,
+			APIVersion:   vpa.Spec.TargetRef.APIVersion,
+			ResourceType: vpa.Spec.TargetRef.Kind,
+			ResourceName: vpa.Spec.TargetRef.Name,
+			Reason:       reason,
+			VPACreatedAt: vpa.CreationTimestamp.Time,
+		})
+	}
+
+	// Skip VPA if the target resource does not exist
+	err := resourceExists(ctx, vpa.Spec.TargetRef.Name, vpa.Spec.TargetRef.Kind, namespace, clientset)
+	switch {
+	case errors.Is(err, ErrTargetNotFound):
+		l.Info("target does not exist. Skipping", "namespace", namespace, "vpa", vpa.Name, "resourceType", vpa.Spec.TargetRef.Kind, "resourceName", vpa.Spec.TargetRef.Name)
+		skip(SkipTargetNotFound)
+		return results, skipped, nil
+	case errors.Is(err, ErrUnsupportedKind):
+		// The recommendations are still reported, but without the current config to compare against
+		l.Debug("target kind not supported. Current config will not be reported", "namespace", namespace, "vpa", vpa.Name, "resourceType", vpa.Spec.TargetRef.Kind, "resourceName", vpa.Spec.TargetRef.Name)
+		skip(SkipUnsupportedKind)
+	case err != nil:
+		return nil, nil, err
+	}
+
+	// Fetched once per VPA and shared by each of its container recommendations
+	target, err := getWorkload(ctx, vpa.Spec.TargetRef.Name, vpa.Spec.TargetRef.Kind, namespace, clientset)
+	if err != nil && !errors.Is(err, ErrUnsupportedKind) {
+		return nil, nil, err
+	}
+
+	ownerKind, ownerName := vpa.Spec.TargetRef.Kind, vpa.Spec.TargetRef.Name
+	if target.found && opts.ResolveOwners {
+		ownerKind, ownerName, err = resolveOwner(ctx, clientset, namespace, ownerKind, target.meta)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// Recently created workloads haven't been running long enough for the recommendations to be meaningful
+	if target.found && opts.MinWorkloadAge > 0 {
+		if age := time.Since(target.meta.CreationTimestamp.Time); age < opts.MinWorkloadAge {
+			l.Info("target younger than minimum workload age. Skipping", "namespace", namespace, "vpa", vpa.Name, "resourceType", vpa.Spec.TargetRef.Kind, "resourceName", vpa.Spec.TargetRef.Name, "age", age.Round(time.Second).String())
+			skip(SkipYoungerThanMinAge)
+			return results, skipped, nil
+		}
+	}
+
+	// Operators set their own requests, so would fight any change made from the recommendations
+	if target.found && operatorManaged(target.meta.Labels, opts.OperatorLabels) {
+		l.Info("target managed by an operator. Skipping", "namespace", namespace, "vpa", vpa.Name, "resourceType", vpa.Spec.TargetRef.Kind, "resourceName", vpa.Spec.TargetRef.Name)
+		skip(SkipOperatorManaged)
+		return results, skipped, nil
+	}
+
+	// The recommendation is nil until the recommender first processes the VPA, and may be empty for a while after a spec change.
+	// Any recommendation left over from before the recommender stopped providing one is stale
+	notProvided, pendingReason := recommendationNotProvided(vpa)
+	if notProvided || vpa.Status.Recommendation == nil || len(vpa.Status.Recommendation.ContainerRecommendations) == 0 {
+		l.Info("No per-container recommendations yet. The resource may have a VPA unsupported parent controller such as SeldonDeployment", "namespace", namespace, "vpa", vpa.Name, "resourceType", vpa.Spec.TargetRef.Kind, "resourceName", vpa.Spec.TargetRef.Name, "reason", pendingReason)
+		if opts.IncludePending {
+			results = append(results, ContainerConfig{
+				Namespace:       namespace,
+				ResourceType:    vpa.Spec.TargetRef.Kind,
+				ResourceName:    vpa.Spec.TargetRef.Name,
+				VPAName:         vpa.Name,
+				VPAManagedBy:    vpaManagedBy(vpa.Labels),
+				TargetCPUStr:    Pending,
+				TargetMemoryStr: Pending,
+				PendingReason:   pendingReason,
+				Team:            ns.team,
+				WorkloadLabels:  target.meta.Labels,
+				CreatedAt:       target.meta.CreationTimestamp.Time,
+				OwnerKind:       ownerKind,
+				OwnerName:       ownerName,
+			})
+		} else {
+			skip(SkipNoRecommendation)
+		}
+		return results, skipped, nil
+	}
+
+	// VPAs in Auto/Recreate mode set the requests of the pods they recreate, so the template no longer reflects what is running
+	if target.found && opts.LivePodsWhenMutated && vpaMutatesPods(vpa) {
+		spec, found, err := runningPodSpec(ctx, clientset, namespace, target.selector)
+		if err != nil {
+			return nil, nil, err
+		}
+		if found {
+			target.podSpec, target.source = spec, sourcePods
+		} else {
+			l.Info("No running pods found. Comparing against the pod template", "namespace", namespace, "vpa", vpa.Name, "resourceType", vpa.Spec.TargetRef.Kind, "resourceName", vpa.Spec.TargetRef.Name)
+		}
+	}
+
+	// Compare against the requests across the running pods, which differ from the template mid-rollout
+	var podsMatch *bool
+	if target.found && opts.CompareLivePods != "" && target.source == sourceTemplate {
+		specs, err := runningPodSpecs(ctx, clientset, namespace, target.selector)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(specs) > 0 {
+			match := podsMatchTemplate(target.podSpec, specs)
+			if !match {
+				l.Warn("Running pods' requests differ from the pod template. A rollout may be in progress", "namespace", namespace, "vpa", vpa.Name, "resourceType", vpa.Spec.TargetRef.Kind, "resourceName", vpa.Spec.TargetRef.Name, "pods", len(specs))
+			}
+			podsMatch = &match
+			target.podSpec, target.source = aggregatePodSpecs(target.podSpec, specs, opts.CompareLivePods), sourcePods
+		} else {
+			l.Info("No running pods found. Comparing against the pod template", "namespace", namespace, "vpa", vpa.Name, "resourceType", vpa.Spec.TargetRef.Kind, "resourceName", vpa.Spec.TargetRef.Name)
+		}
+	}
+
+	// The QoS class the pods would have if every container recommendation was applied
+	var currentQOS v1.PodQOSClass
+	if target.found {
+		currentQOS = podQOSClass(target.podSpec, nil)
+	}
+
+	// Pod-level resources only need reading when a container doesn't set its own. Running pods aren't checked, as the
+	// VPA sets container-level requests on the pods it mutates
+	var podResources *v1.ResourceRequirements
+	if target.found && target.source == sourceTemplate && needsPodResources(target.podSpec) {
+		podResources, err = getPodResources(ctx, clientset, vpa.Spec.TargetRef.Kind, namespace, vpa.Spec.TargetRef.Name)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// Invalid, but produced by some buggy generators. Surfaced as it makes the comparison ambiguous
+	for _, name := range duplicateContainers(target.podSpec) {
+		l.Warn("Container name is listed more than once in the pod template. Set -dedupe-containers to compare against the one with the larger requests", "namespace", namespace, "resourceType", vpa.Spec.TargetRef.Kind, "resourceName", vpa.Spec.TargetRef.Name, "container", name)
+	}
+	containers := indexContainers(target.podSpec, opts.DedupeContainers)
+
+	// Teams can set their own headroom via annotations on the workload, overriding the flags
+	cpuMultiplier, err := workloadMultiplier(target.meta.Annotations, CPUMultiplierAnnotation, opts.CPUMultiplier)
+	if err != nil {
+		l.Warn("Invalid multiplier annotation. Using -cpu-multiplier", "namespace", namespace, "vpa", vpa.Name, "error", err)
+	}
+	memoryMultiplier, err := workloadMultiplier(target.meta.Annotations, MemoryMultiplierAnnotation, opts.MemoryMultiplier)
+	if err != nil {
+		l.Warn("Invalid multiplier annotation. Using -memory-multiplier", "namespace", namespace, "vpa", vpa.Name, "error", err)
+	}
+
+	team := ns.team
+	if t, found := target.meta.Labels[opts.TeamLabel]; found && opts.TeamLabel != "" {
+		team = t
+	}
+
+	// The requests of each container with a row once its recommendation is applied, keyed by lower cased name
+	recommendedRequests := make(map[string]v1.ResourceList)
+	for _, containerRecommendation := range vpa.Status.Recommendation.ContainerRecommendations {
+		if slices.ContainsFunc(opts.ContainerDenylist, func(name string) bool { return strings.EqualFold(name, containerRecommendation.ContainerName) }) {
+			l.Debug("Container denylisted. Skipping", "namespace", namespace, "vpa", vpa.Name, "container", containerRecommendation.ContainerName)
+			continue
+		}
+
+		// Get the recommendation from the selected source, in K8s format
+		recommended := recommendationBound(containerRecommendation, opts.RecommendationSource)
+		memoryTarget, memoryTargetBytes := RecommendedMemory(recommended)
+		cpuTargetStr, cpuTargetRaw := RecommendedCPU(recommended)
+
+		// Headroom on top of the recommendation, used in the diffs and every output
+		if cpuTargetStr != Pending && cpuMultiplier != 1 {
+			cpuTargetRaw = multiplyCPU(cpuTargetRaw, cpuMultiplier)
+			cpuTargetStr = fmt.Sprintf("%dm", cpuTargetRaw)
+		}
+		if memoryTarget != Pending && memoryMultiplier != 1 {
+			memoryTargetBytes = multiplyMemory(memoryTargetBytes, memoryMultiplier)
+			memoryTarget = FormatMemory(memoryTargetBytes)
+		}
+
+		// Get the upper bound, used to gauge how spiky the workload is compared to the target
+		memoryUpper, memoryUpperBytes := RecommendedMemory(containerRecommendation.UpperBound)
+		cpuUpper, cpuUpperRaw := RecommendedCPU(containerRecommendation.UpperBound)
+
+		// Get the lower bound, which along with the upper bound shows how stable the usage is
+		_, memoryLowerBytes := RecommendedMemory(containerRecommendation.LowerBound)
+		_, cpuLowerRaw := RecommendedCPU(containerRecommendation.LowerBound)
+
+		// Get the capped target, which is the uncapped target clamped by the VPA's minAllowed/maxAllowed
+		memoryCapped, memoryCappedBytes := RecommendedMemory(containerRecommendation.Target)
+		cpuCapped, cpuCappedRaw := RecommendedCPU(containerRecommendation.Target)
+
+		// A resource the VPA doesn't control is never acted on, so it's neither reported nor diffed
+		cpuControlled, memoryControlled := controlledResources(vpa.Spec.ResourcePolicy, containerRecommendation.ContainerName)
+		if !cpuControlled {
+			cpuTargetStr, cpuTargetRaw, cpuUpper, cpuUpperRaw, cpuLowerRaw, cpuCapped, cpuCappedRaw = Pending, 0, Pending, 0, 0, Pending, 0
+		}
+		if !memoryControlled {
+			memoryTarget, memoryTargetBytes, memoryUpper, memoryUpperBytes, memoryLowerBytes, memoryCapped, memoryCappedBytes = Pending, 0, Pending, 0, 0, Pending, 0
+		}
+
+		// Get the current container resource config and calculate the diff from the recommendation
+		resourceConfig := currentResourceConfig(target, containers, containerRecommendation.ContainerName, opts.CompareAgainst, l)
+		resourceConfig = applyPodResources(resourceConfig, podResources, opts.CompareAgainst)
+
+		if belowMinimum(resourceConfig, opts.MinCurrentCPU, opts.MinCurrentMem) {
+			l.Debug("Current requests below -min-current-cpu/-min-current-memory. Skipping", "namespace", namespace, "vpa", vpa.Name, "container", containerRecommendation.ContainerName, "currentCPU", resourceConfig.CurrentCPUStr, "currentMemory", resourceConfig.CurrentMemStr)
+			continue
+		}
+
+		r := ContainerConfig{
+			Namespace:        namespace,
+			ResourceType:     vpa.Spec.TargetRef.Kind,
+			ResourceName:     vpa.Spec.TargetRef.Name,
+			ContainerName:    containerRecommendation.ContainerName,
+			VPAName:          vpa.Name,
+			VPAManagedBy:     vpaManagedBy(vpa.Labels),
+			TargetCPUStr:     cpuTargetStr,
+			TargetMemoryStr:  memoryTarget,
+			TargetCPU:        cpuTargetRaw,
+			TargetMemory:     memoryTargetBytes,
+			UpperCPUStr:      cpuUpper,
+			UpperMemoryStr:   memoryUpper,
+			UpperCPU:         cpuUpperRaw,
+			UpperMemory:      memoryUpperBytes,
+			LowerCPU:         cpuLowerRaw,
+			LowerMemory:      memoryLowerBytes,
+			CappedCPUStr:     cpuCapped,
+			CappedMemoryStr:  memoryCapped,
+			CappedCPU:        cpuCappedRaw,
+			CappedMemory:     memoryCappedBytes,
+			CurrentConfig:    resourceConfig,
+			QOSClass:         currentQOS,
+			Team:             team,
+			WorkloadLabels:   target.meta.Labels,
+			CPUMultiplier:    strconv.FormatFloat(cpuMultiplier, 'g', -1, 64),
+			MemoryMultiplier: strconv.FormatFloat(memoryMultiplier, 'g', -1, 64),
+			CreatedAt:        target.meta.CreationTimestamp.Time,
+			OwnerKind:        ownerKind,
+			OwnerName:        ownerName,
+			PodsMatch:        podsMatch,
+			CPUNotControlled: !cpuControlled,
+			MemNotControlled: !memoryControlled,
+		}
+
+		// Only diffed when both the recommendation and current value are available
+		if resourceConfig.CurrentCPUStr != NotSet && cpuTargetStr != Pending {
+			r.CurrentConfig.CPUDiff = cpuTargetRaw - resourceConfig.CurrentCPU
+		}
+
+		if resourceConfig.CurrentMemStr != NotSet && memoryTarget != Pending {
+			r.CurrentConfig.MemDiff = memoryTargetBytes - resourceConfig.CurrentMem
+		}
+
+		if resourceConfig.CurrentCPUStr != NotSet && cpuCapped != Pending {
+			r.CurrentConfig.CappedCPUDiff = cpuCappedRaw - resourceConfig.CurrentCPU
+		}
+
+		if resourceConfig.CurrentMemStr != NotSet && memoryCapped != Pending {
+			r.CurrentConfig.CappedMemDiff = memoryCappedBytes - resourceConfig.CurrentMem
+		}
+
+		// Spikes up to the upper bound would be OOM killed
+		if FixedMemory(resourceConfig) && memoryUpper != Pending && memoryUpperBytes > resourceConfig.LimitMem {
+			l.Warn("Memory request equals the limit, which is below the VPA upper bound. Consider raising the limit", "namespace", namespace, "resourceType", r.ResourceType, "resourceName", r.ResourceName, "container", r.ContainerName, "limit", resourceConfig.LimitMem, "upperBound", memoryUpperBytes)
+		}
+
+		r.HasHPA = workloadHasHPA(r.ResourceType, r.ResourceName, ns.hasHPAMapping)
+		r.HPAScalesOnCPU = workloadHasHPA(r.ResourceType, r.ResourceName, ns.cpuHPAMapping)
+		if target.found {
+			hasPDB := workloadHasPDB(target.podLabels, ns.pdbs)
+			r.HasPDB = &hasPDB
+		}
+
+		l.Debug("Container resourceConfig", "container", r.ContainerName, "currentCPURaw", resourceConfig.CurrentCPU, "currentMemoryRaw", resourceConfig.CurrentMem, "recommendedMemory", memoryTargetBytes, "recommendedCPU", cpuTargetRaw, "hasHPA", r.HasHPA)
+
+		recommendedRequests[strings.ToLower(r.ContainerName)] = rowRequests(r)
+
+		results = append(results, r)
+	}
+
+	// From the recommendations as reported in the rows, i.e. from the -recommendation-source after the multipliers, leaving out
+	// the resources the VPA doesn't control and the containers without a row
+	if target.found {
+		recommendedQOS := podQOSClass(target.podSpec, recommendedRequests)
+		for i := range results {
+			results[i].RecommendedQOS = recommendedQOS
+		}
+	}
+
+	return results, skipped, nil
+}
+
+// ListClusterVPAs lists the VPAs across every namespace in a single call, grouped by namespace.
+func ListClusterVPAs(ctx context.Context, vpaClient verticalAutoscalingClientSet.Interface) (map[string][]verticalAutoscaling.VerticalPodAutoscaler, error) {
+	list, err := vpaClient.AutoscalingV1().VerticalPodAutoscalers(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, &APIError{Op: "listing VPAs across all namespaces", Err: err}
+	}
+
+	byNamespace := make(map[string][]verticalAutoscaling.VerticalPodAutoscaler)
+	for _, vpa := range list.Items {
+		byNamespace[vpa.Namespace] = append(byNamespace[vpa.Namespace], vpa)
+	}
+
+	return byNamespace, nil
+}
+
+// SystemNamespaces are skipped when querying every namespace, unless -include-system-namespaces is set
+var SystemNamespaces = []string{"kube-system", "kube-public", "kube-node-lease"}
+
+// GetNamespaces returns all the namespaces in the cluster, excluding the well-known system namespaces unless includeSystem is set.
+// Namespaces being deleted are also excluded unless includeTerminating is set, as listing their workloads gives partial results
+func GetNamespaces(ctx context.Context, client kubernetes.Interface, includeSystem, includeTerminating bool) ([]string, error) {
+	result := make([]string, 0)
+
+	namespaces, err := client.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return result, &APIError{Op: "listing namespaces", Err: err}
+	}
+
+	for _, ns := range namespaces.Items {
+		if !includeSystem && slices.Contains(SystemNamespaces, ns.Name) {
+			continue
+		}
+		if !includeTerminating && ns.Status.Phase == v1.NamespaceTerminating {
+			continue
+		}
+		result = append(result, ns.Name)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,101 @@
+package collector_test
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"slices"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	autoscaling "k8s.io/api/autoscaling/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	verticalAutoscaling "k8s.io/autoscaler/vertical-pod-autoscaler/pkg/apis/autoscaling.k8s.io/v1"
+	vpaFake "k8s.io/autoscaler/vertical-pod-autoscaler/pkg/client/clientset/versioned/fake"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"get-recommendations/collector"
+)
+
+func vpaFor(namespace, name, kind, target string, recommended v1.ResourceList) *verticalAutoscaling.VerticalPodAutoscaler {
+	return &verticalAutoscaling.VerticalPodAutoscaler{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+		Spec: verticalAutoscaling.VerticalPodAutoscalerSpec{
+			TargetRef: &autoscaling.CrossVersionObjectReference{APIVersion: "apps/v1", Kind: kind, Name: target},
+		},
+		Status: verticalAutoscaling.VerticalPodAutoscalerStatus{
+			Recommendation: &verticalAutoscaling.RecommendedPodResources{
+				ContainerRecommendations: []verticalAutoscaling.RecommendedContainerResources{
+					{ContainerName: "app", Target: recommended, UncappedTarget: recommended, LowerBound: recommended, UpperBound: recommended},
+				},
+			},
+		},
+	}
+}
+
+func TestCollect(t *testing.T) {
+	replicas := int32(2)
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "team", Name: "api"},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Template: v1.PodTemplateSpec{Spec: v1.PodSpec{Containers: []v1.Container{{
+				Name: "app",
+				Resources: v1.ResourceRequirements{Requests: v1.ResourceList{
+					v1.ResourceCPU:    resource.MustParse("100m"),
+					v1.ResourceMemory: resource.MustParse("128Mi"),
+				}},
+			}}}},
+		},
+	}
+	recommended := v1.ResourceList{v1.ResourceCPU: resource.MustParse("250m"), v1.ResourceMemory: resource.MustParse("256Mi")}
+
+	clientset := fake.NewSimpleClientset(
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team"}},
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "kube-system"}},
+		deployment,
+	)
+	vpaClient := vpaFake.NewSimpleClientset(
+		vpaFor("team", "api", "Deployment", "api", recommended),
+		vpaFor("team", "orphan", "Deployment", "deleted", recommended),
+		vpaFor("kube-system", "dns", "Deployment", "coredns", recommended),
+	)
+
+	// The zero Options query every namespace other than the system ones
+	c := collector.NewCollector(clientset, vpaClient, collector.Options{}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	results, err := c.Collect(context.Background())
+	if err != nil {
+		t.Fatalf("Collect() error = %v", err)
+	}
+
+	if got := c.Namespaces(); !slices.Equal(got, []string{"team"}) {
+		t.Errorf("Namespaces() = %v, want [team]", got)
+	}
+
+	if len(results) != 1 {
+		t.Fatalf("Collect() returned %d results, want 1: %+v", len(results), results)
+	}
+	r := results[0]
+	if r.ResourceType != "Deployment" || r.ResourceName != "api" || r.ContainerName != "app" || r.VPAName != "api" {
+		t.Errorf("result is for %s %s container %s of VPA %s, want Deployment api container app of VPA api", r.ResourceType, r.ResourceName, r.ContainerName, r.VPAName)
+	}
+	if r.TargetCPUStr != "250m" || r.TargetMemoryStr != "256Mi" {
+		t.Errorf("targets = %s, %s, want 250m, 256Mi", r.TargetCPUStr, r.TargetMemoryStr)
+	}
+	if r.CurrentConfig.CurrentCPUStr != "100m" || r.CurrentConfig.CurrentMemStr != "128Mi" {
+		t.Errorf("current = %s, %s, want 100m, 128Mi", r.CurrentConfig.CurrentCPUStr, r.CurrentConfig.CurrentMemStr)
+	}
+	if r.CurrentConfig.CPUDiff != 150 || r.CurrentConfig.MemDiff != 128*collector.Mebibyte {
+		t.Errorf("diffs = %d, %d, want 150, %d", r.CurrentConfig.CPUDiff, r.CurrentConfig.MemDiff, 128*collector.Mebibyte)
+	}
+	if r.CurrentConfig.Replicas != 2 {
+		t.Errorf("replicas = %d, want 2", r.CurrentConfig.Replicas)
+	}
+
+	skipped := c.Skipped()
+	if len(skipped) != 1 || skipped[0].VPAName != "orphan" || skipped[0].Reason != collector.SkipTargetNotFound {
+		t.Errorf("Skipped() = %+v, want the orphan VPA with reason %q", skipped, collector.SkipTargetNotFound)
+	}
+}
@@ -0,0 +1,40 @@
+package collector
+
+import (
+	"errors"
+	"fmt"
+
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// ErrTargetNotFound is returned when the resource targeted by a VPA does not exist.
+var ErrTargetNotFound = errors.New("target resource not found")
+
+// ErrUnsupportedKind is returned when the VPA targets a kind whose pod template can't be read, such as a custom resource.
+var ErrUnsupportedKind = errors.New("unsupported target kind")
+
+// APIError is returned when a request to the K8s API fails for any reason other than the resource not being found.
+type APIError struct {
+	Op  string // description of the request, e.g. "getting deployment default/app"
+	Err error
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("error %s: %v", e.Op, e.Err)
+}
+
+func (e *APIError) Unwrap() error {
+	return e.Err
+}
+
+// classifyGetError maps the error from getting a target resource onto ErrTargetNotFound or an *APIError.
+func classifyGetError(op string, err error) error {
+	if err == nil {
+		return nil
+	}
+	if k8serrors.IsNotFound(err) {
+		return fmt.Errorf("%s: %w", op, ErrTargetNotFound)
+	}
+
+	return &APIError{Op: op, Err: err}
+}
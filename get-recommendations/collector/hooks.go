@@ -0,0 +1,60 @@
+package collector
+
+import "slices"
+
+// Extension point for custom builds which need org-specific fields. Register a processor (and optionally a column to output
+// its fields) from an init function, e.g. in a file added to get-recommendations:
+//
+//	func init() {
+//		collector.RegisterResultProcessor(func(r *collector.ContainerConfig) {
+//			r.Extra["costCentre"] = costCentres[r.Namespace]
+//		})
+//		collector.RegisterExtraColumn("costCentre", "Cost Centre")
+//	}
+
+// ResultProcessor is applied to each result after the recommendations have been collected and before they're written.
+// It may modify any field of the result, and record derived fields in Extra.
+type ResultProcessor func(r *ContainerConfig)
+
+var resultProcessors []ResultProcessor
+
+// RegisterResultProcessor adds a processor, applied in the order registered.
+func RegisterResultProcessor(p ResultProcessor) {
+	resultProcessors = append(resultProcessors, p)
+}
+
+// ExtraColumn is an output column holding the Extra field with the given key.
+type ExtraColumn struct {
+	Key    string
+	Header string
+}
+
+var extraColumns []ExtraColumn
+
+// RegisterExtraColumn appends a column outputting the Extra field with the given key. Must be called from an init function
+// so that the column can be selected via -output-fields.
+func RegisterExtraColumn(key, header string) {
+	extraColumns = append(extraColumns, ExtraColumn{Key: key, Header: header})
+}
+
+// ExtraColumns returns the registered columns, in the order registered.
+func ExtraColumns() []ExtraColumn {
+	return slices.Clone(extraColumns)
+}
+
+// ApplyResultProcessors runs each registered processor over every result. Collect doesn't apply them itself, so that
+// get-recommendations can run them once its own checks have filled in the rest of the fields.
+func ApplyResultProcessors(results []ContainerConfig) {
+	if len(resultProcessors) == 0 {
+		return
+	}
+
+	for i := range results {
+		if results[i].Extra == nil {
+			results[i].Extra = make(map[string]string)
+		}
+		for _, p := range resultProcessors {
+			p(&results[i])
+		}
+	}
+}
@@ -0,0 +1,25 @@
+package collector
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestResultProcessorsAndExtraColumns(t *testing.T) {
+	t.Cleanup(func() { resultProcessors, extraColumns = nil, nil })
+
+	RegisterResultProcessor(func(r *ContainerConfig) { r.Extra["costCentre"] = "cc-" + r.Namespace })
+	RegisterExtraColumn("costCentre", "Cost Centre")
+
+	results := []ContainerConfig{{Namespace: "payments"}, {Namespace: "search"}}
+	ApplyResultProcessors(results)
+	for _, r := range results {
+		if got, want := r.Extra["costCentre"], "cc-"+r.Namespace; got != want {
+			t.Errorf("Extra[costCentre] = %q, want %q", got, want)
+		}
+	}
+
+	if got, want := ExtraColumns(), []ExtraColumn{{Key: "costCentre", Header: "Cost Centre"}}; !slices.Equal(got, want) {
+		t.Errorf("ExtraColumns() = %v, want %v", got, want)
+	}
+}
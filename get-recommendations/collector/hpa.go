@@ -0,0 +1,59 @@
+package collector
+
+import (
+	"context"
+	"strings"
+
+	autoscaling "k8s.io/api/autoscaling/v2"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// hpaMappings returns a slice containing the targets of every HPA in a namespace, along with the targets of those which
+// scale on CPU utilisation
+func hpaMappings(ctx context.Context, clientset kubernetes.Interface, namespace string) ([]autoscaling.CrossVersionObjectReference, []autoscaling.CrossVersionObjectReference, error) {
+	hpas, err := clientset.AutoscalingV2().HorizontalPodAutoscalers(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, nil, &APIError{Op: "getting HPAs", Err: err}
+	}
+	hasHPAMapping := make([]autoscaling.CrossVersionObjectReference, 0, len(hpas.Items))
+	cpuMapping := make([]autoscaling.CrossVersionObjectReference, 0)
+	for _, hpa := range hpas.Items {
+		hasHPAMapping = append(hasHPAMapping, hpa.Spec.ScaleTargetRef)
+		if hpaScalesOnCPU(hpa) {
+			cpuMapping = append(cpuMapping, hpa.Spec.ScaleTargetRef)
+		}
+	}
+
+	return hasHPAMapping, cpuMapping, nil
+}
+
+// hpaScalesOnCPU returns true if any of the HPA's metrics are CPU. An HPA without metrics defaults to scaling on CPU.
+func hpaScalesOnCPU(hpa autoscaling.HorizontalPodAutoscaler) bool {
+	if len(hpa.Spec.Metrics) == 0 {
+		return true
+	}
+
+	for _, m := range hpa.Spec.Metrics {
+		switch {
+		case m.Type == autoscaling.ResourceMetricSourceType && m.Resource != nil && m.Resource.Name == v1.ResourceCPU:
+			return true
+		case m.Type == autoscaling.ContainerResourceMetricSourceType && m.ContainerResource != nil && m.ContainerResource.Name == v1.ResourceCPU:
+			return true
+		}
+	}
+
+	return false
+}
+
+// workloadHasHPA returns true if any of the HPA targets is the workload. Kinds and names are compared case-insensitively.
+func workloadHasHPA(kind, name string, mappings []autoscaling.CrossVersionObjectReference) bool {
+	for _, hpa := range mappings {
+		if strings.EqualFold(hpa.Kind, kind) && strings.EqualFold(hpa.Name, name) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,81 @@
+package collector
+
+import (
+	"testing"
+
+	autoscaling "k8s.io/api/autoscaling/v2"
+	v1 "k8s.io/api/core/v1"
+)
+
+func TestWorkloadHasHPA(t *testing.T) {
+	mappings := []autoscaling.CrossVersionObjectReference{
+		{Kind: "Deployment", Name: "api", APIVersion: "apps/v1"},
+		{Kind: "StatefulSet", Name: "Cache", APIVersion: "apps/v1"},
+	}
+
+	tests := []struct {
+		name     string
+		kind     string
+		resource string
+		want     bool
+	}{
+		{name: "exact match", kind: "Deployment", resource: "api", want: true},
+		{name: "differently cased kind", kind: "deployment", resource: "api", want: true},
+		{name: "differently cased name", kind: "StatefulSet", resource: "cache", want: true},
+		{name: "no HPA", kind: "Deployment", resource: "worker", want: false},
+		{name: "same name different kind", kind: "DaemonSet", resource: "api", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := workloadHasHPA(tt.kind, tt.resource, mappings); got != tt.want {
+				t.Errorf("workloadHasHPA(%q, %q) = %t, want %t", tt.kind, tt.resource, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWorkloadHasHPANoMappings(t *testing.T) {
+	if workloadHasHPA("Deployment", "api", nil) {
+		t.Error("workloadHasHPA() = true with no HPAs, want false")
+	}
+}
+
+func TestHPAScalesOnCPU(t *testing.T) {
+	tests := []struct {
+		name    string
+		metrics []autoscaling.MetricSpec
+		want    bool
+	}{
+		{name: "defaults to cpu", want: true},
+		{
+			name:    "cpu utilisation",
+			metrics: []autoscaling.MetricSpec{{Type: autoscaling.ResourceMetricSourceType, Resource: &autoscaling.ResourceMetricSource{Name: v1.ResourceCPU}}},
+			want:    true,
+		},
+		{
+			name:    "container cpu",
+			metrics: []autoscaling.MetricSpec{{Type: autoscaling.ContainerResourceMetricSourceType, ContainerResource: &autoscaling.ContainerResourceMetricSource{Name: v1.ResourceCPU, Container: "app"}}},
+			want:    true,
+		},
+		{
+			name:    "memory only",
+			metrics: []autoscaling.MetricSpec{{Type: autoscaling.ResourceMetricSourceType, Resource: &autoscaling.ResourceMetricSource{Name: v1.ResourceMemory}}},
+			want:    false,
+		},
+		{
+			name:    "external metric",
+			metrics: []autoscaling.MetricSpec{{Type: autoscaling.ExternalMetricSourceType}},
+			want:    false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hpa := autoscaling.HorizontalPodAutoscaler{Spec: autoscaling.HorizontalPodAutoscalerSpec{Metrics: tt.metrics}}
+			if got := hpaScalesOnCPU(hpa); got != tt.want {
+				t.Errorf("hpaScalesOnCPU() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
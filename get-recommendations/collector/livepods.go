@@ -0,0 +1,172 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	verticalAutoscaling "k8s.io/autoscaler/vertical-pod-autoscaler/pkg/apis/autoscaling.k8s.io/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Where the current container config was read from
+const (
+	sourceTemplate = "template" // the workload's pod template
+	sourcePods     = "pods"     // a running pod, which the VPA may have mutated
+)
+
+// vpaMutatesPods returns true if the VPA applies its recommendations to the pods it evicts, meaning the running pods may no
+// longer match the workload's pod template. The update mode defaults to Auto when unset.
+func vpaMutatesPods(vpa verticalAutoscaling.VerticalPodAutoscaler) bool {
+	if vpa.Spec.UpdatePolicy == nil || vpa.Spec.UpdatePolicy.UpdateMode == nil {
+		return true
+	}
+
+	mode := *vpa.Spec.UpdatePolicy.UpdateMode
+	return mode == verticalAutoscaling.UpdateModeAuto || mode == verticalAutoscaling.UpdateModeRecreate
+}
+
+// runningPodSpec returns the spec of a running pod selected by the workload's selector, and false if there are none.
+func runningPodSpec(ctx context.Context, client kubernetes.Interface, namespace string, selector *metav1.LabelSelector) (v1.PodSpec, bool, error) {
+	specs, err := runningPodSpecs(ctx, client, namespace, selector)
+	if err != nil || len(specs) == 0 {
+		return v1.PodSpec{}, false, err
+	}
+
+	return specs[0], true, nil
+}
+
+// runningPodSpecs returns the specs of every running pod selected by the workload's selector.
+func runningPodSpecs(ctx context.Context, client kubernetes.Interface, namespace string, selector *metav1.LabelSelector) ([]v1.PodSpec, error) {
+	if selector == nil {
+		return nil, nil
+	}
+
+	s, err := metav1.LabelSelectorAsSelector(selector)
+	if err != nil {
+		return nil, fmt.Errorf("parsing workload selector: %w", err)
+	}
+
+	pods, err := client.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{LabelSelector: s.String()})
+	if err != nil {
+		return nil, &APIError{Op: fmt.Sprintf("listing pods in %s namespace", namespace), Err: err}
+	}
+
+	specs := make([]v1.PodSpec, 0, len(pods.Items))
+	for _, p := range pods.Items {
+		if p.Status.Phase == v1.PodRunning && p.DeletionTimestamp == nil {
+			specs = append(specs, p.Spec)
+		}
+	}
+
+	return specs, nil
+}
+
+// Values for the -compare-live-pods flag, selecting how the requests of the running pods are combined
+const (
+	LiveAverage = "average"
+	LiveMax     = "max"
+)
+
+// aggregatePodSpecs returns the template with the requests and limits of each container replaced by the average or max across
+// the pods, according to mode. Containers which aren't in any of the pods keep the template's values.
+func aggregatePodSpecs(template v1.PodSpec, pods []v1.PodSpec, mode string) v1.PodSpec {
+	spec := *template.DeepCopy()
+	for i := range spec.Containers {
+		c := &spec.Containers[i]
+		values := make(map[string][]int64) // keyed by requests/<resource> or limits/<resource>
+		for _, p := range pods {
+			for _, pc := range p.Containers {
+				if pc.Name != c.Name {
+					continue
+				}
+				for _, name := range []v1.ResourceName{v1.ResourceCPU, v1.ResourceMemory} {
+					if q, found := pc.Resources.Requests[name]; found {
+						values["requests/"+string(name)] = append(values["requests/"+string(name)], quantityValue(name, q))
+					}
+					if q, found := pc.Resources.Limits[name]; found {
+						values["limits/"+string(name)] = append(values["limits/"+string(name)], quantityValue(name, q))
+					}
+				}
+			}
+		}
+
+		for _, name := range []v1.ResourceName{v1.ResourceCPU, v1.ResourceMemory} {
+			if v, found := values["requests/"+string(name)]; found {
+				c.Resources.Requests = setQuantity(c.Resources.Requests, name, combine(v, mode))
+			}
+			if v, found := values["limits/"+string(name)]; found {
+				c.Resources.Limits = setQuantity(c.Resources.Limits, name, combine(v, mode))
+			}
+		}
+	}
+
+	return spec
+}
+
+// podsMatchTemplate returns true if every pod's containers have the same requests as the template's.
+func podsMatchTemplate(template v1.PodSpec, pods []v1.PodSpec) bool {
+	requests := make(map[string]v1.ResourceList, len(template.Containers))
+	for _, c := range template.Containers {
+		requests[c.Name] = c.Resources.Requests
+	}
+
+	for _, p := range pods {
+		for _, c := range p.Containers {
+			want, found := requests[c.Name]
+			if !found {
+				continue
+			}
+			for _, name := range []v1.ResourceName{v1.ResourceCPU, v1.ResourceMemory} {
+				got, wanted := c.Resources.Requests[name], want[name]
+				if got.Cmp(wanted) != 0 {
+					return false
+				}
+			}
+		}
+	}
+
+	return true
+}
+
+// quantityValue returns CPU in millicores and other resources in their base units.
+func quantityValue(name v1.ResourceName, q resource.Quantity) int64 {
+	if name == v1.ResourceCPU {
+		return q.MilliValue()
+	}
+
+	return q.Value()
+}
+
+// setQuantity sets the resource in the list to value, in millicores for CPU and base units otherwise.
+func setQuantity(list v1.ResourceList, name v1.ResourceName, value int64) v1.ResourceList {
+	if list == nil {
+		list = v1.ResourceList{}
+	}
+	if name == v1.ResourceCPU {
+		list[name] = *resource.NewMilliQuantity(value, resource.DecimalSI)
+	} else {
+		list[name] = *resource.NewQuantity(value, resource.BinarySI)
+	}
+
+	return list
+}
+
+// combine returns the max of the values, or their average rounded down.
+func combine(values []int64, mode string) int64 {
+	var total, highest int64
+	for _, v := range values {
+		total += v
+		if v > highest {
+			highest = v
+		}
+	}
+
+	if mode == LiveMax {
+		return highest
+	}
+
+	return total / int64(len(values))
+}
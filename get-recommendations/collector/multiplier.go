@@ -0,0 +1,40 @@
+package collector
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// Annotations on a workload setting the safety multiplier applied to its recommendations, e.g. "1.2" for 20% headroom.
+// Override the -cpu-multiplier and -memory-multiplier
+const (
+	CPUMultiplierAnnotation    = "vpa-recommendations/cpu-multiplier"
+	MemoryMultiplierAnnotation = "vpa-recommendations/memory-multiplier"
+)
+
+// workloadMultiplier returns the multiplier set by the annotation, or global if the workload isn't annotated. An invalid
+// annotation returns global along with an error describing it.
+func workloadMultiplier(annotations map[string]string, annotation string, global float64) (float64, error) {
+	value, found := annotations[annotation]
+	if !found {
+		return global, nil
+	}
+
+	m, err := strconv.ParseFloat(value, 64)
+	if err != nil || m <= 0 || math.IsInf(m, 0) {
+		return global, fmt.Errorf("annotation %s=%q must be a number greater than zero", annotation, value)
+	}
+
+	return m, nil
+}
+
+// multiplyCPU scales the millicores by the multiplier, rounding up to the next millicore.
+func multiplyCPU(millicores int64, m float64) int64 {
+	return int64(math.Ceil(float64(millicores) * m))
+}
+
+// multiplyMemory scales the bytes by the multiplier, rounding up to the next Mi so the patched value is a whole unit.
+func multiplyMemory(bytes int64, m float64) int64 {
+	return int64(math.Ceil(float64(bytes)*m/Mebibyte)) * Mebibyte
+}
@@ -0,0 +1,47 @@
+package collector
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// DefaultOperatorLabels identify workloads created by operators which manage their own resource requests, and so would
+// fight a VPA. Each is either a label key, matching any value, or a key=value pair.
+var DefaultOperatorLabels = []string{
+	"strimzi.io/cluster",
+	"operator.prometheus.io/name",
+	"app.kubernetes.io/managed-by=prometheus-operator",
+	"common.k8s.elastic.co/type",
+	"postgres-operator.crunchydata.com/cluster",
+}
+
+// ParseOperatorLabels parses the comma separated -operator-labels into a selector per entry.
+func ParseOperatorLabels(s string) ([]labels.Selector, error) {
+	selectors := make([]labels.Selector, 0)
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		selector, err := labels.Parse(entry)
+		if err != nil {
+			return nil, fmt.Errorf("parsing operator label %q: %w", entry, err)
+		}
+		selectors = append(selectors, selector)
+	}
+
+	return selectors, nil
+}
+
+// operatorManaged returns true if the workload labels match any of the operator label selectors.
+func operatorManaged(workloadLabels map[string]string, selectors []labels.Selector) bool {
+	for _, s := range selectors {
+		if s.Matches(labels.Set(workloadLabels)) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,37 @@
+package collector
+
+import (
+	"context"
+	"errors"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// maxOwnerDepth bounds how many controller references are followed, in case of a cycle
+const maxOwnerDepth = 5
+
+// resolveOwner returns the kind and name of the workload's top-level controller, following the controller owner references
+// for as long as the owners are kinds which can be read. The workload itself is returned if it has no controller.
+func resolveOwner(ctx context.Context, client kubernetes.Interface, namespace, kind string, meta metav1.ObjectMeta) (string, string, error) {
+	name := meta.Name
+	for i := 0; i < maxOwnerDepth; i++ {
+		ref := metav1.GetControllerOf(&meta)
+		if ref == nil {
+			break
+		}
+		kind, name = ref.Kind, ref.Name
+
+		owner, err := getWorkload(ctx, name, kind, namespace, client)
+		switch {
+		case errors.Is(err, ErrUnsupportedKind), errors.Is(err, ErrTargetNotFound):
+			// Owners such as custom resources can't be read, so are treated as the top level
+			return kind, name, nil
+		case err != nil:
+			return "", "", err
+		}
+		meta = owner.meta
+	}
+
+	return kind, name, nil
+}
@@ -0,0 +1,51 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+
+	policyv1 "k8s.io/api/policy/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+)
+
+// pdbSelectors returns the pod selector of every PodDisruptionBudget in a namespace
+func pdbSelectors(ctx context.Context, clientset kubernetes.Interface, namespace string) ([]labels.Selector, error) {
+	pdbs, err := clientset.PolicyV1().PodDisruptionBudgets(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, &APIError{Op: fmt.Sprintf("listing PDBs in %s namespace", namespace), Err: err}
+	}
+
+	selectors := make([]labels.Selector, 0, len(pdbs.Items))
+	for _, pdb := range pdbs.Items {
+		s, err := pdbSelector(pdb)
+		if err != nil {
+			return nil, fmt.Errorf("parsing selector of PDB %s/%s: %w", namespace, pdb.Name, err)
+		}
+		selectors = append(selectors, s)
+	}
+
+	return selectors, nil
+}
+
+// pdbSelector returns the PDB's pod selector. In policy/v1 an empty selector matches every pod in the namespace, whereas
+// a nil selector matches none.
+func pdbSelector(pdb policyv1.PodDisruptionBudget) (labels.Selector, error) {
+	if pdb.Spec.Selector == nil {
+		return labels.Nothing(), nil
+	}
+
+	return metav1.LabelSelectorAsSelector(pdb.Spec.Selector)
+}
+
+// workloadHasPDB returns true if any of the PDB selectors matches the labels of the workload's pods.
+func workloadHasPDB(podLabels map[string]string, selectors []labels.Selector) bool {
+	for _, s := range selectors {
+		if s.Matches(labels.Set(podLabels)) {
+			return true
+		}
+	}
+
+	return false
+}
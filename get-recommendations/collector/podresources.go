@@ -0,0 +1,91 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// sourcePodResources marks config read from the pod-level resources (K8s 1.32+), used when a container doesn't set its own
+const sourcePodResources = "pod-resources"
+
+// podTemplateResources is the subset of a workload needed to read the pod-level resources. The field is newer than the
+// vendored API types, so it's decoded from the raw object rather than the typed clients.
+type podTemplateResources struct {
+	Spec struct {
+		Template struct {
+			Spec struct {
+				Resources *v1.ResourceRequirements `json:"resources"`
+			} `json:"spec"`
+		} `json:"template"`
+	} `json:"spec"`
+}
+
+// needsPodResources returns true if any regular container is missing a CPU or memory request, so the pod-level resources may apply.
+func needsPodResources(spec v1.PodSpec) bool {
+	for _, c := range spec.Containers {
+		if c.Resources.Requests.Cpu().IsZero() || c.Resources.Requests.Memory().IsZero() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// getPodResources returns the pod-level resources set in the workload's pod template, or nil if they aren't set.
+func getPodResources(ctx context.Context, client kubernetes.Interface, resourceType, namespace, resourceName string) (*v1.ResourceRequirements, error) {
+	// The supported kinds are all in the apps group, with the resource being the lower case plural of the kind
+	raw, err := client.AppsV1().RESTClient().Get().Namespace(namespace).Resource(strings.ToLower(resourceType) + "s").Name(resourceName).DoRaw(ctx)
+	if err != nil {
+		return nil, classifyGetError(fmt.Sprintf("getting %s %s/%s", strings.ToLower(resourceType), namespace, resourceName), err)
+	}
+
+	var obj podTemplateResources
+	err = json.Unmarshal(raw, &obj)
+	if err != nil {
+		return nil, fmt.Errorf("decoding %s %s/%s: %w", strings.ToLower(resourceType), namespace, resourceName, err)
+	}
+
+	return obj.Spec.Template.Spec.Resources, nil
+}
+
+// applyPodResources fills in the CPU and memory which the container doesn't set from the pod-level resources. The pod-level
+// resources are shared by every container in the pod, so the diff is only exact for single container pods.
+func applyPodResources(d ResourceDrift, pod *v1.ResourceRequirements, compareAgainst string) ResourceDrift {
+	if pod == nil || d.ContainerType != ContainerRegular {
+		return d
+	}
+
+	applied := false
+	if d.CurrentCPUStr == NotSet {
+		cpu, basis := pod.Requests.Cpu(), CompareRequests
+		if cpu.IsZero() && compareAgainst == CompareLimits {
+			cpu, basis = pod.Limits.Cpu(), CompareLimits
+		}
+		if !cpu.IsZero() {
+			d.CurrentCPU, d.CurrentCPUStr, d.CPUBasis = cpu.MilliValue(), fmt.Sprintf("%dm", cpu.MilliValue()), basis
+			applied = true
+		}
+	}
+
+	if d.CurrentMemStr == NotSet {
+		mem, basis := pod.Requests.Memory(), CompareRequests
+		if mem.IsZero() && compareAgainst == CompareLimits {
+			mem, basis = pod.Limits.Memory(), CompareLimits
+		}
+		if !mem.IsZero() {
+			d.CurrentMem, d.CurrentMemStr, d.MemBasis = mem.Value(), FormatMemory(mem.Value()), basis
+			applied = true
+		}
+	}
+
+	if applied {
+		d.Source = sourcePodResources
+	}
+
+	return d
+}
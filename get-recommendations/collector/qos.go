@@ -0,0 +1,70 @@
+package collector
+
+import (
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// rowRequests returns the requests the container would have once the recommendation in its row is applied, i.e. the
+// multiplied targets, for podQOSClass. Resources without a recommendation are left out, so keep their current request.
+func rowRequests(r ContainerConfig) v1.ResourceList {
+	requests := v1.ResourceList{}
+	if r.TargetCPUStr != Pending && r.TargetCPU > 0 {
+		requests[v1.ResourceCPU] = *resource.NewMilliQuantity(r.TargetCPU, resource.DecimalSI)
+	}
+	if r.TargetMemoryStr != Pending && r.TargetMemory > 0 {
+		requests[v1.ResourceMemory] = *resource.NewQuantity(r.TargetMemory, resource.BinarySI)
+	}
+
+	return requests
+}
+
+// podQOSClass returns the QoS class of pods created from the spec, following the rules in
+// https://kubernetes.io/docs/concepts/workloads/pods/pod-qos/.
+// If recommended requests are passed, keyed by lower cased container name, they replace the requests of the matching containers
+// first, giving the QoS class the pods would have once the recommendations are applied. Limits are left unchanged.
+func podQOSClass(spec v1.PodSpec, recommended map[string]v1.ResourceList) v1.PodQOSClass {
+	containers := make([]v1.Container, 0, len(spec.InitContainers)+len(spec.Containers))
+	containers = append(containers, spec.InitContainers...)
+	containers = append(containers, spec.Containers...)
+
+	anySet := false
+	guaranteed := true
+
+	for _, c := range containers {
+		requests := c.Resources.Requests.DeepCopy()
+		for name, q := range recommended[strings.ToLower(c.Name)] {
+			if requests == nil {
+				requests = v1.ResourceList{}
+			}
+			requests[name] = q
+		}
+
+		for _, name := range []v1.ResourceName{v1.ResourceCPU, v1.ResourceMemory} {
+			request, hasRequest := requests[name]
+			hasRequest = hasRequest && !request.IsZero()
+			limit, hasLimit := c.Resources.Limits[name]
+			hasLimit = hasLimit && !limit.IsZero()
+
+			if hasRequest || hasLimit {
+				anySet = true
+			}
+
+			// Guaranteed requires a limit on every resource, with any request equal to it (an unset request defaults to the limit)
+			if !hasLimit || (hasRequest && request.Cmp(limit) != 0) {
+				guaranteed = false
+			}
+		}
+	}
+
+	switch {
+	case !anySet:
+		return v1.PodQOSBestEffort
+	case guaranteed:
+		return v1.PodQOSGuaranteed
+	default:
+		return v1.PodQOSBurstable
+	}
+}
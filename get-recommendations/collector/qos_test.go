@@ -0,0 +1,45 @@
+package collector
+
+import (
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func TestRecommendedQOSClassWithMultiplier(t *testing.T) {
+	// Guaranteed, with the requests equal to the limits
+	spec := v1.PodSpec{Containers: []v1.Container{{
+		Name: "App",
+		Resources: v1.ResourceRequirements{
+			Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("500m"), v1.ResourceMemory: resource.MustParse("512Mi")},
+			Limits:   v1.ResourceList{v1.ResourceCPU: resource.MustParse("500m"), v1.ResourceMemory: resource.MustParse("512Mi")},
+		},
+	}}}
+
+	tests := []struct {
+		name      string
+		cpu       int64
+		mem       int64
+		memStatus string
+		want      v1.PodQOSClass
+	}{
+		{name: "recommendation equal to the limits", cpu: 500, mem: 512 * Mebibyte, want: v1.PodQOSGuaranteed},
+		{name: "multiplier moves the request off the limit", cpu: multiplyCPU(500, 1.2), mem: multiplyMemory(512*Mebibyte, 1), want: v1.PodQOSBurstable},
+		{name: "pending memory keeps the current request", cpu: 500, memStatus: Pending, want: v1.PodQOSGuaranteed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := ContainerConfig{ContainerName: "app", TargetCPUStr: "set", TargetCPU: tt.cpu, TargetMemoryStr: "set", TargetMemory: tt.mem}
+			if tt.memStatus != "" {
+				r.TargetMemoryStr = tt.memStatus
+			}
+
+			got := podQOSClass(spec, map[string]v1.ResourceList{"app": rowRequests(r)})
+			if got != tt.want {
+				t.Errorf("podQOSClass() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
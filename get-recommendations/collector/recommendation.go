@@ -0,0 +1,125 @@
+package collector
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	verticalAutoscaling "k8s.io/autoscaler/vertical-pod-autoscaler/pkg/apis/autoscaling.k8s.io/v1"
+)
+
+// Values for the -recommendation-source flag, selecting which bound is used as the recommended value
+const (
+	SourceTarget     = "target"     // the uncapped target
+	SourceLowerBound = "lowerBound" // for teams happy to size tightly
+	SourceUpperBound = "upperBound" // for teams wanting headroom for spikes
+)
+
+var RecommendationSources = []string{SourceTarget, SourceLowerBound, SourceUpperBound}
+
+// controlledResources returns whether the VPA controls the CPU and memory of the container, read from the controlledResources
+// of the container's policy, or else the "*" default policy. Both are controlled when neither sets them.
+func controlledResources(policy *verticalAutoscaling.PodResourcePolicy, containerName string) (cpu, memory bool) {
+	if policy == nil {
+		return true, true
+	}
+
+	var matched *verticalAutoscaling.ContainerResourcePolicy
+	for i, p := range policy.ContainerPolicies {
+		if p.ContainerName == containerName {
+			matched = &policy.ContainerPolicies[i]
+			break
+		}
+		if p.ContainerName == verticalAutoscaling.DefaultContainerResourcePolicy {
+			matched = &policy.ContainerPolicies[i]
+		}
+	}
+	if matched == nil || matched.ControlledResources == nil {
+		return true, true
+	}
+
+	return slices.Contains(*matched.ControlledResources, v1.ResourceCPU), slices.Contains(*matched.ControlledResources, v1.ResourceMemory)
+}
+
+// recommendationBound returns the bound of the container recommendation selected by source.
+func recommendationBound(r verticalAutoscaling.RecommendedContainerResources, source string) v1.ResourceList {
+	switch source {
+	case SourceLowerBound:
+		return r.LowerBound
+	case SourceUpperBound:
+		return r.UpperBound
+	default:
+		return r.UncappedTarget
+	}
+}
+
+// RecommendedCPU returns the CPU in the recommendation in K8s format, along with its value in millicores.
+// Some recommender versions only populate certain resources, so a missing key is reported as PENDING rather than a misleading zero.
+func RecommendedCPU(resources v1.ResourceList) (string, int64) {
+	q, found := resources[v1.ResourceCPU]
+	if !found {
+		return Pending, 0
+	}
+
+	return q.String(), q.MilliValue()
+}
+
+// RecommendedMemory returns the memory in the recommendation in K8s format converted to Mi, along with its value in bytes.
+// Recommender versions differ in the scale they report memory in, e.g. plain bytes, Ki or decimal k, so the value is always
+// read in bytes via the Quantity rather than relying on its suffix. A missing key is reported as PENDING.
+func RecommendedMemory(resources v1.ResourceList) (string, int64) {
+	q, found := resources[v1.ResourceMemory]
+	if !found {
+		return Pending, 0
+	}
+
+	bytes := q.Value()
+	return FormatMemory(bytes), bytes
+}
+
+// Mebibyte is the size of 1Mi, the unit the memory columns and patches are formatted in
+const Mebibyte = 1024 * 1024
+
+// FormatMemory formats bytes in Mi, rounding up so that a value which isn't a whole number of Mi, e.g. one reported in Ki
+// or decimal M, is never understated. In particular a non-zero value below 1Mi is reported as 1Mi rather than 0Mi.
+func FormatMemory(bytes int64) string {
+	mi := bytes / Mebibyte
+	if bytes%Mebibyte > 0 {
+		mi++
+	}
+
+	return fmt.Sprintf("%dMi", mi)
+}
+
+// belowMinimum returns true if the current requests are below each of the set minimums, so the container is too small to be
+// worth rightsizing. A zero minimum isn't checked, and a request which isn't set, or couldn't be read, is never below the
+// minimum, as the missing request is worth reporting.
+func belowMinimum(d ResourceDrift, minCPU, minMem int64) bool {
+	if minCPU == 0 && minMem == 0 {
+		return false
+	}
+
+	cpuBelow := minCPU == 0 || (d.CurrentCPU > 0 && d.CurrentCPU < minCPU)
+	memBelow := minMem == 0 || (d.CurrentMem > 0 && d.CurrentMem < minMem)
+
+	return cpuBelow && memBelow
+}
+
+// recommendationNotProvided returns true if the VPA's RecommendationProvided condition is False, along with the condition's
+// reason and message explaining why, e.g. no pods matched or there isn't enough data yet.
+func recommendationNotProvided(vpa verticalAutoscaling.VerticalPodAutoscaler) (bool, string) {
+	for _, c := range vpa.Status.Conditions {
+		if c.Type == verticalAutoscaling.RecommendationProvided && c.Status == v1.ConditionFalse {
+			parts := make([]string, 0, 2)
+			for _, p := range []string{c.Reason, c.Message} {
+				if p != "" {
+					parts = append(parts, p)
+				}
+			}
+			return true, strings.Join(parts, ": ")
+		}
+	}
+
+	return false, ""
+}
@@ -0,0 +1,100 @@
+package collector
+
+import (
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func TestRecommendedMissingMemory(t *testing.T) {
+	uncapped := v1.ResourceList{v1.ResourceCPU: resource.MustParse("250m")}
+
+	mem, memBytes := RecommendedMemory(uncapped)
+	if mem != Pending || memBytes != 0 {
+		t.Errorf("recommendedMemory() = %q, %d, want %q, 0", mem, memBytes, Pending)
+	}
+
+	cpu, millicores := RecommendedCPU(uncapped)
+	if cpu != "250m" || millicores != 250 {
+		t.Errorf("recommendedCPU() = %q, %d, want \"250m\", 250", cpu, millicores)
+	}
+}
+
+func TestRecommendedValues(t *testing.T) {
+	tests := []struct {
+		name          string
+		resources     v1.ResourceList
+		wantCPU       string
+		wantMillis    int64
+		wantMemory    string
+		wantMemoryRaw int64
+	}{
+		{
+			name:          "both set",
+			resources:     v1.ResourceList{v1.ResourceCPU: resource.MustParse("1"), v1.ResourceMemory: resource.MustParse("256Mi")},
+			wantCPU:       "1",
+			wantMillis:    1000,
+			wantMemory:    "256Mi",
+			wantMemoryRaw: 256 * 1024 * 1024,
+		},
+		{
+			name:       "explicit zero is not pending",
+			resources:  v1.ResourceList{v1.ResourceCPU: resource.MustParse("0"), v1.ResourceMemory: resource.MustParse("0")},
+			wantCPU:    "0",
+			wantMemory: "0Mi",
+		},
+		{
+			name:       "empty",
+			resources:  v1.ResourceList{},
+			wantCPU:    Pending,
+			wantMemory: Pending,
+		},
+		{
+			name:       "nil",
+			wantCPU:    Pending,
+			wantMemory: Pending,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cpu, millis := RecommendedCPU(tt.resources)
+			if cpu != tt.wantCPU || millis != tt.wantMillis {
+				t.Errorf("recommendedCPU() = %q, %d, want %q, %d", cpu, millis, tt.wantCPU, tt.wantMillis)
+			}
+
+			mem, raw := RecommendedMemory(tt.resources)
+			if mem != tt.wantMemory || raw != tt.wantMemoryRaw {
+				t.Errorf("recommendedMemory() = %q, %d, want %q, %d", mem, raw, tt.wantMemory, tt.wantMemoryRaw)
+			}
+		})
+	}
+}
+
+func TestRecommendedMemoryScales(t *testing.T) {
+	tests := []struct {
+		name      string
+		memory    string
+		wantStr   string
+		wantBytes int64
+	}{
+		{name: "bytes", memory: "268435456", wantStr: "256Mi", wantBytes: 256 * Mebibyte},
+		{name: "Ki", memory: "262144Ki", wantStr: "256Mi", wantBytes: 256 * Mebibyte},
+		{name: "Mi", memory: "256Mi", wantStr: "256Mi", wantBytes: 256 * Mebibyte},
+		{name: "Gi", memory: "0.25Gi", wantStr: "256Mi", wantBytes: 256 * Mebibyte},
+		{name: "exponent", memory: "268435456e0", wantStr: "256Mi", wantBytes: 256 * Mebibyte},
+		{name: "decimal k", memory: "262144k", wantStr: "250Mi", wantBytes: 262144000},
+		{name: "decimal M rounds up", memory: "500M", wantStr: "477Mi", wantBytes: 500000000},
+		{name: "below 1Mi", memory: "512Ki", wantStr: "1Mi", wantBytes: 512 * 1024},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mem, bytes := RecommendedMemory(v1.ResourceList{v1.ResourceMemory: resource.MustParse(tt.memory)})
+			if mem != tt.wantStr || bytes != tt.wantBytes {
+				t.Errorf("recommendedMemory(%s) = %q, %d, want %q, %d", tt.memory, mem, bytes, tt.wantStr, tt.wantBytes)
+			}
+		})
+	}
+}
@@ -0,0 +1,33 @@
+package collector
+
+import (
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// replicaCount returns the number of pods the workload runs, used to multiply per-pod values into per-workload totals.
+// Deployments, StatefulSets and ReplicaSets use the desired replicas, which default to 1 when unset, and DaemonSets the number of nodes
+// they're desired to be scheduled on. Zero for any other kind.
+func replicaCount(obj runtime.Object) int32 {
+	switch o := obj.(type) {
+	case *appsv1.Deployment:
+		if o.Spec.Replicas == nil {
+			return 1
+		}
+		return *o.Spec.Replicas
+	case *appsv1.StatefulSet:
+		if o.Spec.Replicas == nil {
+			return 1
+		}
+		return *o.Spec.Replicas
+	case *appsv1.ReplicaSet:
+		if o.Spec.Replicas == nil {
+			return 1
+		}
+		return *o.Spec.Replicas
+	case *appsv1.DaemonSet:
+		return o.Status.DesiredNumberScheduled
+	}
+
+	return 0
+}
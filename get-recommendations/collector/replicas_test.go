@@ -0,0 +1,37 @@
+package collector
+
+import (
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestReplicaCount(t *testing.T) {
+	three := int32(3)
+	zero := int32(0)
+
+	tests := []struct {
+		name string
+		obj  runtime.Object
+		want int32
+	}{
+		{name: "deployment", obj: &appsv1.Deployment{Spec: appsv1.DeploymentSpec{Replicas: &three}}, want: 3},
+		{name: "deployment scaled to zero", obj: &appsv1.Deployment{Spec: appsv1.DeploymentSpec{Replicas: &zero}}, want: 0},
+		{name: "deployment defaults to one", obj: &appsv1.Deployment{}, want: 1},
+		{name: "statefulset", obj: &appsv1.StatefulSet{Spec: appsv1.StatefulSetSpec{Replicas: &three}}, want: 3},
+		{name: "statefulset defaults to one", obj: &appsv1.StatefulSet{}, want: 1},
+		{name: "replicaset", obj: &appsv1.ReplicaSet{Spec: appsv1.ReplicaSetSpec{Replicas: &three}}, want: 3},
+		{name: "daemonset", obj: &appsv1.DaemonSet{Status: appsv1.DaemonSetStatus{DesiredNumberScheduled: 5}}, want: 5},
+		{name: "unsupported kind", obj: &v1.Pod{}, want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := replicaCount(tt.obj); got != tt.want {
+				t.Errorf("replicaCount() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package collector
+
+import (
+	"fmt"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// Labels set by manage-vpas on the VPAs it creates
+const (
+	ManagedByLabel            = "managed-by"
+	ManagedByValue            = "vpa-recommendations-script"
+	SourceControlManagedLabel = "source-control-managed"
+)
+
+// Values of ContainerConfig.VPAManagedBy
+const (
+	ManagedByScript   = "script"   // created by manage-vpas
+	ManagedByExternal = "external" // defined elsewhere, e.g. in Git
+)
+
+// vpaManagedBy returns whether the VPA was created by manage-vpas, based on its labels, or defined elsewhere.
+func vpaManagedBy(labels map[string]string) string {
+	if labels[ManagedByLabel] == ManagedByValue || labels[SourceControlManagedLabel] == "false" {
+		return ManagedByScript
+	}
+
+	return ManagedByExternal
+}
+
+// Pending is reported in place of the recommendation for VPAs which have not produced one yet
+const Pending = "PENDING"
+
+// NotSet is reported in place of the current config for containers without requests
+const NotSet = "NOT_SET"
+
+// NotControlled is reported in place of the recommendation for resources outside the controlledResources of the VPA's
+// resource policy, which the VPA never acts on
+const NotControlled = "NOT_CONTROLLED"
+
+// Values for the -compare-against flag
+const (
+	CompareRequests = "requests"
+	CompareLimits   = "limits"
+)
+
+// ContainerConfig is the result for a single container recommendation of a VPA, alongside the container's current config.
+// Each is written as a row of the results.
+type ContainerConfig struct {
+	Namespace         string
+	ResourceType      string
+	ResourceName      string
+	ContainerName     string
+	VPAName           string
+	TargetCPUStr      string
+	TargetMemoryStr   string
+	TargetCPU         int64 // millicores
+	TargetMemory      int64 // bytes
+	UpperCPUStr       string
+	UpperMemoryStr    string
+	UpperCPU          int64 // millicores
+	UpperMemory       int64 // bytes
+	LowerCPU          int64 // millicores, zero if the recommendation doesn't have a lower bound
+	LowerMemory       int64 // bytes, zero if the recommendation doesn't have a lower bound
+	CappedCPUStr      string
+	CappedMemoryStr   string
+	CappedCPU         int64 // millicores, the target after the VPA resource policy is applied
+	CappedMemory      int64 // bytes, the target after the VPA resource policy is applied
+	CurrentConfig     ResourceDrift
+	HasHPA            bool
+	HPAScalesOnCPU    bool
+	ExceedsNodeMemory *bool  // nil if the nodes could not be checked
+	Team              string // value of the -team-label label, empty if not set
+	QOSClass          v1.PodQOSClass
+	RecommendedQOS    v1.PodQOSClass    // QoS class of the pods once the recommendations are applied
+	CPUTrend          string            // change in the CPU recommendation since the previous results, empty if unknown
+	MemTrend          string            // change in the memory recommendation since the previous results, empty if unknown
+	Extra             map[string]string // fields derived by any registered result processors, see hooks.go
+	CreatedAt         time.Time         // creation time of the workload, zero if the kind is not supported
+	VPAManagedBy      string            // whether the VPA was created by manage-vpas or defined elsewhere, e.g. in Git
+	HasPDB            *bool             // whether the pods are covered by a PodDisruptionBudget, nil if the target could not be read
+	PendingReason     string            // reason from the RecommendationProvided condition, for PENDING rows
+	PodsMatch         *bool             // whether the running pods' requests match the template, nil unless -compare-live-pods is set
+	WorkloadLabels    map[string]string // labels of the workload, read by the -extra-label-columns
+	CPUMultiplier     string            // headroom applied to the CPU recommendation, from -cpu-multiplier or the workload's annotation
+	MemoryMultiplier  string            // headroom applied to the memory recommendation, from -memory-multiplier or the workload's annotation
+	CPUNotControlled  bool              // the VPA doesn't control CPU, so the CPU recommendation is treated as PENDING
+	MemNotControlled  bool              // the VPA doesn't control memory, so the memory recommendation is treated as PENDING
+
+	// Top-level controller of the workload, only resolved with -group-by=owner
+	OwnerKind, OwnerName string
+}
+
+// ResourceDrift is the current resource config of a container, and its diff from the recommendation.
+type ResourceDrift struct {
+	CurrentCPUStr string
+	CurrentMemStr string
+	CurrentCPU    int64
+	CurrentMem    int64
+	CPUDiff       int64
+	MemDiff       int64
+	CappedCPUDiff int64  // diff from the capped target, only reported with -capped-diffs
+	CappedMemDiff int64  // diff from the capped target, only reported with -capped-diffs
+	Replicas      int32  // desired number of pods for the workload
+	CPUBasis      string // whether CurrentCPU was read from the requests or limits
+	MemBasis      string // whether CurrentMem was read from the requests or limits
+	Source        string // whether the config was read from the pod template or a running pod
+	ContainerType string // whether the recommendation matched a regular, init or ephemeral container, or none
+	ContainerName string // name of the matched container as spelled in the workload, which may differ in case from the VPA's
+
+	// The requests and limits as set, regardless of the basis. Zero if not set
+	RequestCPU, RequestMem int64
+	LimitCPU, LimitMem     int64
+}
+
+// Basis describes whether the current values came from the requests or limits, e.g. "requests" or "cpu=limits;memory=requests".
+func (d ResourceDrift) Basis() string {
+	if d.CPUBasis == d.MemBasis {
+		return d.CPUBasis
+	}
+
+	return fmt.Sprintf("cpu=%s;memory=%s", d.CPUBasis, d.MemBasis)
+}
+
+// FixedMemory returns true if the container's memory request equals its limit, leaving no room to burst above the request.
+func FixedMemory(d ResourceDrift) bool {
+	return d.RequestMem > 0 && d.RequestMem == d.LimitMem
+}
@@ -0,0 +1,23 @@
+package collector
+
+import "time"
+
+// Reasons a VPA is missing from, or only partially reported in, the results
+const (
+	SkipTargetNotFound    = "target not found"
+	SkipUnsupportedKind   = "unsupported target kind, current config not reported"
+	SkipNoRecommendation  = "no per-container recommendations yet"
+	SkipYoungerThanMinAge = "target younger than -min-workload-age"
+	SkipOperatorManaged   = "target managed by an operator"
+)
+
+// SkippedVPA records why a VPA was skipped, for the -explain report.
+type SkippedVPA struct {
+	Namespace    string
+	VPAName      string
+	APIVersion   string // of the target
+	ResourceType string
+	ResourceName string
+	Reason       string
+	VPACreatedAt time.Time
+}
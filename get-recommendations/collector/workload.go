@@ -0,0 +1,237 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// workload is the VPA target resource, holding the parts needed to compare against the recommendations.
+type workload struct {
+	found     bool // false if the kind is not supported
+	meta      metav1.ObjectMeta
+	podSpec   v1.PodSpec
+	replicas  int32 // desired number of pods
+	selector  *metav1.LabelSelector
+	podLabels map[string]string // labels of the pod template
+	source    string            // where podSpec was read from
+}
+
+// SupportedKinds are the target kinds whose current config can be read, i.e. the cases handled by getWorkload
+var SupportedKinds = []string{"Deployment", "StatefulSet", "DaemonSet", "ReplicaSet"}
+
+// getWorkload fetches the VPA target resource. Unsupported kinds return ErrUnsupportedKind along with a workload with found set to false.
+func getWorkload(ctx context.Context, resourceName, resourceType, namespace string, client kubernetes.Interface) (workload, error) {
+	w := workload{}
+
+	switch resourceType {
+	case "Deployment":
+		deployment, err := client.AppsV1().Deployments(namespace).Get(ctx, resourceName, metav1.GetOptions{})
+		if err != nil {
+			return w, classifyGetError(fmt.Sprintf("getting deployment %s/%s", namespace, resourceName), err)
+		}
+		w = workload{found: true, meta: deployment.ObjectMeta, podSpec: deployment.Spec.Template.Spec, replicas: replicaCount(deployment), selector: deployment.Spec.Selector, podLabels: deployment.Spec.Template.Labels, source: sourceTemplate}
+
+	case "StatefulSet":
+		statefulset, err := client.AppsV1().StatefulSets(namespace).Get(ctx, resourceName, metav1.GetOptions{})
+		if err != nil {
+			return w, classifyGetError(fmt.Sprintf("getting statefulset %s/%s", namespace, resourceName), err)
+		}
+		w = workload{found: true, meta: statefulset.ObjectMeta, podSpec: statefulset.Spec.Template.Spec, replicas: replicaCount(statefulset), selector: statefulset.Spec.Selector, podLabels: statefulset.Spec.Template.Labels, source: sourceTemplate}
+
+	case "DaemonSet":
+		daemonset, err := client.AppsV1().DaemonSets(namespace).Get(ctx, resourceName, metav1.GetOptions{})
+		if err != nil {
+			return w, classifyGetError(fmt.Sprintf("getting daemonset %s/%s", namespace, resourceName), err)
+		}
+		w = workload{found: true, meta: daemonset.ObjectMeta, podSpec: daemonset.Spec.Template.Spec, replicas: replicaCount(daemonset), selector: daemonset.Spec.Selector, podLabels: daemonset.Spec.Template.Labels, source: sourceTemplate}
+
+	case "ReplicaSet":
+		replicaset, err := client.AppsV1().ReplicaSets(namespace).Get(ctx, resourceName, metav1.GetOptions{})
+		if err != nil {
+			return w, classifyGetError(fmt.Sprintf("getting replicaset %s/%s", namespace, resourceName), err)
+		}
+		w = workload{found: true, meta: replicaset.ObjectMeta, podSpec: replicaset.Spec.Template.Spec, replicas: replicaCount(replicaset), selector: replicaset.Spec.Selector, podLabels: replicaset.Spec.Template.Labels, source: sourceTemplate}
+
+	default:
+		return w, fmt.Errorf("%s %s/%s: %w", resourceType, namespace, resourceName, ErrUnsupportedKind)
+	}
+
+	return w, nil
+}
+
+// Type of container in the workload that a VPA container recommendation was matched to
+const (
+	ContainerRegular   = "regular"
+	ContainerInit      = "init"
+	ContainerEphemeral = "ephemeral"
+	ContainerMissing   = "missing"
+)
+
+// containerIndex holds a workload's containers keyed by their lower cased name, so that each recommendation can be matched
+// without scanning the containers. Where names are duplicated the first container is kept, or with dedupe the one with the
+// larger requests.
+type containerIndex struct {
+	regular, init, ephemeral map[string]v1.Container
+}
+
+// indexContainers builds the containerIndex for a pod spec. Built once per workload and shared by its recommendations.
+func indexContainers(spec v1.PodSpec, dedupe bool) containerIndex {
+	index := func(containers []v1.Container) map[string]v1.Container {
+		m := make(map[string]v1.Container, len(containers))
+		for _, c := range containers {
+			key := strings.ToLower(c.Name)
+			if existing, found := m[key]; !found || (dedupe && largerRequests(c, existing)) {
+				m[key] = c
+			}
+		}
+		return m
+	}
+
+	return containerIndex{
+		regular:   index(spec.Containers),
+		init:      index(spec.InitContainers),
+		ephemeral: index(ephemeralContainers(spec)),
+	}
+}
+
+// duplicateContainers returns the names listed by more than one regular container, or more than one init container, in the
+// pod spec. Names are compared case-insensitively, as they're matched to the recommendations.
+func duplicateContainers(spec v1.PodSpec) []string {
+	duplicates := make([]string, 0)
+	for _, containers := range [][]v1.Container{spec.Containers, spec.InitContainers} {
+		seen := make(map[string]int, len(containers))
+		for _, c := range containers {
+			key := strings.ToLower(c.Name)
+			seen[key]++
+			if seen[key] == 2 {
+				duplicates = append(duplicates, c.Name)
+			}
+		}
+	}
+
+	return duplicates
+}
+
+// largerRequests returns whether a requests more CPU than b, or the same CPU and more memory. Ties return false, so the
+// first of identical containers is kept.
+func largerRequests(a, b v1.Container) bool {
+	aCPU, bCPU := a.Resources.Requests.Cpu().MilliValue(), b.Resources.Requests.Cpu().MilliValue()
+	if aCPU != bCPU {
+		return aCPU > bCPU
+	}
+
+	return a.Resources.Requests.Memory().Value() > b.Resources.Requests.Memory().Value()
+}
+
+// currentResourceConfig returns the current resource config of a container in the workload. The regular containers are
+// preferred, as the VPA recommends for those, but init and ephemeral containers are also matched so that the mismatch is surfaced.
+// Container names are matched case-insensitively.
+func currentResourceConfig(w workload, containers containerIndex, containerName, compareAgainst string, logger *slog.Logger) ResourceDrift {
+	key := strings.ToLower(containerName)
+	regular, isRegular := containers.regular[key]
+	initContainer, isInit := containers.init[key]
+	ephemeral, isEphemeral := containers.ephemeral[key]
+
+	var d ResourceDrift
+	switch {
+	case isRegular:
+		d = getContainerResourceConfig(regular, compareAgainst)
+		d.ContainerType = ContainerRegular
+		if isInit {
+			logger.Warn("Container name is used by both a regular and init container. Compared against the regular container", "resourceName", w.meta.Name, "namespace", w.meta.Namespace, "container", containerName)
+		}
+	case isInit:
+		d = getContainerResourceConfig(initContainer, compareAgainst)
+		d.ContainerType = ContainerInit
+		logger.Warn("VPA recommendation matches an init container rather than a regular container", "resourceName", w.meta.Name, "namespace", w.meta.Namespace, "container", containerName)
+	case isEphemeral:
+		d = getContainerResourceConfig(ephemeral, compareAgainst)
+		d.ContainerType = ContainerEphemeral
+	case w.found:
+		d.ContainerType = ContainerMissing
+	}
+	d.Replicas = w.replicas
+	d.Source = w.source
+
+	return d
+}
+
+// ephemeralContainers returns the ephemeral containers as containers, so they can be searched in the same way. Only pods
+// (read with -live-pods-when-auto) have ephemeral containers, as they're injected for debugging rather than set in templates.
+func ephemeralContainers(spec v1.PodSpec) []v1.Container {
+	containers := make([]v1.Container, 0, len(spec.EphemeralContainers))
+	for _, e := range spec.EphemeralContainers {
+		containers = append(containers, v1.Container{Name: e.Name, Resources: e.Resources})
+	}
+
+	return containers
+}
+
+// getContainerResourceConfig returns the current CPU/memory requests for the container.
+// When compareAgainst is compareLimits, the limits are used for any resource which does not have a request set.
+func getContainerResourceConfig(container v1.Container, compareAgainst string) ResourceDrift {
+	d := ResourceDrift{ContainerName: container.Name}
+
+	cpuQuantity, memQuantity := container.Resources.Requests.Cpu(), container.Resources.Requests.Memory()
+	d.CPUBasis, d.MemBasis = CompareRequests, CompareRequests
+	d.RequestCPU, d.RequestMem = cpuQuantity.MilliValue(), memQuantity.Value()
+	d.LimitCPU, d.LimitMem = container.Resources.Limits.Cpu().MilliValue(), container.Resources.Limits.Memory().Value()
+
+	if compareAgainst == CompareLimits {
+		if cpuQuantity.IsZero() {
+			cpuQuantity = container.Resources.Limits.Cpu()
+			d.CPUBasis = CompareLimits
+		}
+		if memQuantity.IsZero() {
+			memQuantity = container.Resources.Limits.Memory()
+			d.MemBasis = CompareLimits
+		}
+	}
+
+	cpu := cpuQuantity.MilliValue()
+	if cpu == 0 {
+		d.CurrentCPUStr = NotSet
+	} else {
+		d.CurrentCPUStr = fmt.Sprintf("%dm", cpu)
+		d.CurrentCPU = cpu
+	}
+
+	mem := memQuantity.Value()
+	if mem == 0 {
+		d.CurrentMemStr = NotSet
+	} else {
+		d.CurrentMemStr = FormatMemory(mem)
+		d.CurrentMem = mem
+	}
+
+	return d
+}
+
+// resourceExists returns nil if the VPA target exists. Otherwise ErrTargetNotFound, ErrUnsupportedKind or an *APIError is returned.
+func resourceExists(ctx context.Context, resourceName, resourceType, namespace string, client kubernetes.Interface) error {
+	switch resourceType {
+	case "Deployment":
+		_, err := client.AppsV1().Deployments(namespace).Get(ctx, resourceName, metav1.GetOptions{})
+		return classifyGetError(fmt.Sprintf("getting deployment %s (%s)", resourceName, namespace), err)
+
+	case "StatefulSet":
+		_, err := client.AppsV1().StatefulSets(namespace).Get(ctx, resourceName, metav1.GetOptions{})
+		return classifyGetError(fmt.Sprintf("getting statefulset %s (%s)", resourceName, namespace), err)
+
+	case "DaemonSet":
+		_, err := client.AppsV1().DaemonSets(namespace).Get(ctx, resourceName, metav1.GetOptions{})
+		return classifyGetError(fmt.Sprintf("getting daemonset %s (%s)", resourceName, namespace), err)
+
+	case "ReplicaSet":
+		_, err := client.AppsV1().ReplicaSets(namespace).Get(ctx, resourceName, metav1.GetOptions{})
+		return classifyGetError(fmt.Sprintf("getting replicaset %s (%s)", resourceName, namespace), err)
+	}
+
+	return fmt.Errorf("%s %s (%s): %w", resourceType, resourceName, namespace, ErrUnsupportedKind)
+}
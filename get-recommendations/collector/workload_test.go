@@ -0,0 +1,105 @@
+package collector
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func containerWithRequests(name, cpu, mem string) v1.Container {
+	return v1.Container{
+		Name: name,
+		Resources: v1.ResourceRequirements{Requests: v1.ResourceList{
+			v1.ResourceCPU:    resource.MustParse(cpu),
+			v1.ResourceMemory: resource.MustParse(mem),
+		}},
+	}
+}
+
+func TestCurrentResourceConfig(t *testing.T) {
+	w := workload{found: true, podSpec: v1.PodSpec{
+		Containers:          []v1.Container{containerWithRequests("App", "250m", "256Mi"), containerWithRequests("shared", "100m", "64Mi")},
+		InitContainers:      []v1.Container{containerWithRequests("migrate", "50m", "32Mi"), containerWithRequests("shared", "10m", "16Mi")},
+		EphemeralContainers: []v1.EphemeralContainer{{EphemeralContainerCommon: v1.EphemeralContainerCommon{Name: "debugger"}}},
+	}}
+	l := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	tests := []struct {
+		name      string
+		container string
+		wantType  string
+		wantCPU   string
+		wantName  string
+	}{
+		{name: "case-insensitive match", container: "app", wantType: ContainerRegular, wantCPU: "250m", wantName: "App"},
+		{name: "regular preferred over init", container: "shared", wantType: ContainerRegular, wantCPU: "100m", wantName: "shared"},
+		{name: "init container", container: "migrate", wantType: ContainerInit, wantCPU: "50m", wantName: "migrate"},
+		{name: "ephemeral container", container: "debugger", wantType: ContainerEphemeral, wantCPU: NotSet, wantName: "debugger"},
+		{name: "missing container", container: "sidecar", wantType: ContainerMissing, wantCPU: "", wantName: ""},
+	}
+
+	containers := indexContainers(w.podSpec, false)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := currentResourceConfig(w, containers, tt.container, CompareRequests, l)
+			if d.ContainerType != tt.wantType || d.CurrentCPUStr != tt.wantCPU || d.ContainerName != tt.wantName {
+				t.Errorf("currentResourceConfig(%q) = type %q, cpu %q, name %q, want type %q, cpu %q, name %q", tt.container, d.ContainerType, d.CurrentCPUStr, d.ContainerName, tt.wantType, tt.wantCPU, tt.wantName)
+			}
+		})
+	}
+}
+
+func TestDuplicateContainerNames(t *testing.T) {
+	w := workload{found: true, podSpec: v1.PodSpec{
+		Containers: []v1.Container{
+			containerWithRequests("app", "100m", "128Mi"),
+			containerWithRequests("App", "500m", "64Mi"),
+			containerWithRequests("sidecar", "50m", "32Mi"),
+		},
+	}}
+	l := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	if got := duplicateContainers(w.podSpec); len(got) != 1 || got[0] != "App" {
+		t.Errorf("duplicateContainers() = %v, want [App]", got)
+	}
+
+	tests := []struct {
+		name    string
+		dedupe  bool
+		wantCPU string
+	}{
+		{name: "first container kept", dedupe: false, wantCPU: "100m"},
+		{name: "larger requests kept with dedupe", dedupe: true, wantCPU: "500m"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := currentResourceConfig(w, indexContainers(w.podSpec, tt.dedupe), "app", CompareRequests, l)
+			if d.CurrentCPUStr != tt.wantCPU {
+				t.Errorf("currentResourceConfig(dedupe=%t) cpu = %q, want %q", tt.dedupe, d.CurrentCPUStr, tt.wantCPU)
+			}
+		})
+	}
+}
+
+// BenchmarkCurrentResourceConfig matches a recommendation for every container of a workload with many sidecars.
+func BenchmarkCurrentResourceConfig(b *testing.B) {
+	var spec v1.PodSpec
+	for i := 0; i < 50; i++ {
+		spec.Containers = append(spec.Containers, containerWithRequests(fmt.Sprintf("Container-%d", i), "100m", "128Mi"))
+	}
+	w := workload{found: true, podSpec: spec}
+	l := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		containers := indexContainers(w.podSpec, false)
+		for _, c := range spec.Containers {
+			currentResourceConfig(w, containers, c.Name, CompareRequests, l)
+		}
+	}
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"get-recommendations/collector"
+)
+
+// column is a field of the output. The key is used to select columns via -output-fields.
+type column struct {
+	key    string
+	header string
+	value  func(r collector.ContainerConfig) string
+}
+
+// columns lists every output column in the default order. New columns should be appended and schemaVersion bumped.
+var columns = []column{
+	{"namespace", "namespace", func(r collector.ContainerConfig) string { return r.Namespace }},
+	{"resourceType", "resourceType", func(r collector.ContainerConfig) string { return r.ResourceType }},
+	{"resourceName", "resourceName", func(r collector.ContainerConfig) string { return r.ResourceName }},
+	{"containerName", "containerName", func(r collector.ContainerConfig) string { return r.ContainerName }},
+	{"targetCPU", "VPA Target CPU", func(r collector.ContainerConfig) string { return controlled(r.TargetCPUStr, r.CPUNotControlled) }},
+	{"targetMemory", "VPA Target Memory", func(r collector.ContainerConfig) string { return controlled(r.TargetMemoryStr, r.MemNotControlled) }},
+	{"currentCPU", "Current CPU Requests", func(r collector.ContainerConfig) string { return r.CurrentConfig.CurrentCPUStr }},
+	{"currentMemory", "Current Memory Requests", func(r collector.ContainerConfig) string { return r.CurrentConfig.CurrentMemStr }},
+	{"cpuDiff", "CPU Diff (VPA-Current)", func(r collector.ContainerConfig) string { return strconv.FormatInt(r.CurrentConfig.CPUDiff, 10) }},
+	{"memoryDiff", "Memory Diff (VPA-Current)", func(r collector.ContainerConfig) string { return strconv.FormatInt(r.CurrentConfig.MemDiff, 10) }},
+	{"hasHPA", "HPA Enabled", func(r collector.ContainerConfig) string { return strconv.FormatBool(r.HasHPA) }},
+	{"currentBasis", "Current Basis", func(r collector.ContainerConfig) string { return r.CurrentConfig.Basis() }},
+	{"upperCPU", "VPA Upper Bound CPU", func(r collector.ContainerConfig) string { return controlled(r.UpperCPUStr, r.CPUNotControlled) }},
+	{"upperMemory", "VPA Upper Bound Memory", func(r collector.ContainerConfig) string { return controlled(r.UpperMemoryStr, r.MemNotControlled) }},
+	{"cpuHeadroom", "CPU Headroom (Upper/Target)", func(r collector.ContainerConfig) string { return headroomRatio(r.UpperCPU, r.TargetCPU) }},
+	{"memoryHeadroom", "Memory Headroom (Upper/Target)", func(r collector.ContainerConfig) string { return headroomRatio(r.UpperMemory, r.TargetMemory) }},
+	{"cpuTrend", "CPU Change Since Previous", func(r collector.ContainerConfig) string { return r.CPUTrend }},
+	{"memoryTrend", "Memory Change Since Previous", func(r collector.ContainerConfig) string { return r.MemTrend }},
+	{"qosClass", "QoS Class", func(r collector.ContainerConfig) string { return string(r.QOSClass) }},
+	{"recommendedQOSClass", "Recommended QoS Class", func(r collector.ContainerConfig) string { return string(r.RecommendedQOS) }},
+	{"qosClassChanges", "QoS Class Changes", func(r collector.ContainerConfig) string { return strconv.FormatBool(r.QOSClass != r.RecommendedQOS) }},
+	{"exceedsNodeMemory", "Exceeds Node Allocatable Memory", func(r collector.ContainerConfig) string { return formatOptionalBool(r.ExceedsNodeMemory) }},
+	{"team", "team", func(r collector.ContainerConfig) string { return r.Team }},
+	{"primaryDriver", "Primary Driver", primaryDriver},
+	{"currentSource", "Current Source", func(r collector.ContainerConfig) string { return r.CurrentConfig.Source }},
+	{"containerType", "Container Type", func(r collector.ContainerConfig) string { return r.CurrentConfig.ContainerType }},
+	{"workloadAge", "Workload Age", func(r collector.ContainerConfig) string { return formatAge(r.CreatedAt) }},
+	{"vpaManagedBy", "VPA Managed By", func(r collector.ContainerConfig) string { return r.VPAManagedBy }},
+	{"hasPDB", "PDB Covered", func(r collector.ContainerConfig) string { return formatOptionalBool(r.HasPDB) }},
+	{"cpuStability", "CPU Stability ((Upper-Lower)/Target)", func(r collector.ContainerConfig) string {
+		if r.TargetCPUStr == collector.Pending || r.UpperCPUStr == collector.Pending {
+			return ""
+		}
+		return stabilityScore(r.LowerCPU, r.UpperCPU, r.TargetCPU)
+	}},
+	{"memoryStability", "Memory Stability ((Upper-Lower)/Target)", func(r collector.ContainerConfig) string {
+		if r.TargetMemoryStr == collector.Pending || r.UpperMemoryStr == collector.Pending {
+			return ""
+		}
+		return stabilityScore(r.LowerMemory, r.UpperMemory, r.TargetMemory)
+	}},
+	{"memoryRequestEqualsLimit", "Memory Request Equals Limit", func(r collector.ContainerConfig) string {
+		return strconv.FormatBool(collector.FixedMemory(r.CurrentConfig))
+	}},
+	{"pendingReason", "Pending Reason", func(r collector.ContainerConfig) string { return r.PendingReason }},
+	{"podsMatchTemplate", "Pods Match Template", func(r collector.ContainerConfig) string { return formatOptionalBool(r.PodsMatch) }},
+	{"cpuMultiplier", "CPU Multiplier", func(r collector.ContainerConfig) string { return r.CPUMultiplier }},
+	{"memoryMultiplier", "Memory Multiplier", func(r collector.ContainerConfig) string { return r.MemoryMultiplier }},
+	{"replicas", "Replicas", func(r collector.ContainerConfig) string { return strconv.Itoa(int(r.CurrentConfig.Replicas)) }},
+	{"confidence", "Confidence", confidenceTier},
+}
+
+// cappedColumns are appended to the selected columns with -capped-diffs. They compare the current requests against the capped
+// Target, i.e. after the VPA's resource policy is applied, alongside the uncapped diffs. The policy effect is the capped minus
+// the uncapped target, so a negative value shows how far maxAllowed is holding the recommendation down.
+var cappedColumns = []column{
+	{"cappedTargetCPU", "VPA Capped Target CPU", func(r collector.ContainerConfig) string { return controlled(r.CappedCPUStr, r.CPUNotControlled) }},
+	{"cappedTargetMemory", "VPA Capped Target Memory", func(r collector.ContainerConfig) string { return controlled(r.CappedMemoryStr, r.MemNotControlled) }},
+	{"cappedCPUDiff", "Capped CPU Diff (VPA-Current)", func(r collector.ContainerConfig) string { return strconv.FormatInt(r.CurrentConfig.CappedCPUDiff, 10) }},
+	{"cappedMemoryDiff", "Capped Memory Diff (VPA-Current)", func(r collector.ContainerConfig) string { return strconv.FormatInt(r.CurrentConfig.CappedMemDiff, 10) }},
+	{"cpuPolicyEffect", "CPU Policy Effect (Capped-Uncapped)", func(r collector.ContainerConfig) string {
+		if !bothRecommended(r.CappedCPUStr, r.TargetCPUStr) {
+			return ""
+		}
+		return strconv.FormatInt(r.CappedCPU-r.TargetCPU, 10)
+	}},
+	{"memoryPolicyEffect", "Memory Policy Effect (Capped-Uncapped)", func(r collector.ContainerConfig) string {
+		if !bothRecommended(r.CappedMemoryStr, r.TargetMemoryStr) {
+			return ""
+		}
+		return strconv.FormatInt(r.CappedMemory-r.TargetMemory, 10)
+	}},
+}
+
+// bothRecommended reports whether both recommendations are available.
+func bothRecommended(a, b string) bool {
+	return a != "" && a != collector.Pending && b != "" && b != collector.Pending
+}
+
+// controlled returns the recommendation, or NOT_CONTROLLED if the VPA doesn't control the resource.
+func controlled(recommendation string, uncontrolled bool) string {
+	if uncontrolled {
+		return collector.NotControlled
+	}
+	return recommendation
+}
+
+// labelColumns returns a column per workload label key, headed with the key and empty for workloads without the label.
+func labelColumns(keys []string) []column {
+	cols := make([]column, 0, len(keys))
+	for _, key := range keys {
+		key = strings.TrimSpace(key)
+		if key == "" {
+			continue
+		}
+		cols = append(cols, column{"label:" + key, key, func(r collector.ContainerConfig) string { return r.WorkloadLabels[key] }})
+	}
+
+	return cols
+}
+
+// extraColumns returns a column for each registered via collector.RegisterExtraColumn.
+func extraColumns() []column {
+	registered := collector.ExtraColumns()
+	cols := make([]column, 0, len(registered))
+	for _, c := range registered {
+		cols = append(cols, column{c.Key, c.Header, func(r collector.ContainerConfig) string { return r.Extra[c.Key] }})
+	}
+
+	return cols
+}
+
+// columnKeys returns the keys of every column, in the default order.
+func columnKeys() []string {
+	keys := make([]string, 0, len(columns))
+	for _, c := range columns {
+		keys = append(keys, c.key)
+	}
+
+	return keys
+}
+
+// selectColumns returns the columns matching the comma separated keys, in the order given.
+// All columns are returned if fields is empty.
+func selectColumns(fields string) ([]column, error) {
+	if fields == "" {
+		return columns, nil
+	}
+
+	byKey := make(map[string]column, len(columns))
+	for _, c := range columns {
+		byKey[c.key] = c
+	}
+
+	selected := make([]column, 0)
+	for _, key := range strings.Split(fields, ",") {
+		c, found := byKey[strings.TrimSpace(key)]
+		if !found {
+			return nil, fmt.Errorf("unknown output field %q. Valid fields are: %s", key, strings.Join(columnKeys(), ", "))
+		}
+		selected = append(selected, c)
+	}
+
+	return selected, nil
+}
+
+// humanizeDiffs formats the selected diff columns with units, e.g. -256Mi or +150m, rather than raw bytes and millicores.
+// The raw values are kept in a column following each. With totals the diffs are multiplied by replicas, see formatDiffs.
+func humanizeDiffs(cols []column, totals bool) []column {
+	humanized := make([]column, 0, len(cols))
+	for _, c := range cols {
+		diff := diffOf(c.key, totals)
+		switch c.key {
+		case "cpuDiff", "cappedCPUDiff":
+			humanized = append(humanized,
+				column{c.key, c.header, func(r collector.ContainerConfig) string { return formatSignedCPU(diff(r)) }},
+				column{c.key + "Raw", rawHeader(c.header, "millicores"), c.value})
+		case "memoryDiff", "cappedMemoryDiff":
+			humanized = append(humanized,
+				column{c.key, c.header, func(r collector.ContainerConfig) string { return formatSignedMemory(diff(r)) }},
+				column{c.key + "Raw", rawHeader(c.header, "bytes"), c.value})
+		default:
+			humanized = append(humanized, c)
+		}
+	}
+
+	return humanized
+}
+
+// rawHeader returns the header of the raw values of a humanized diff column, e.g. "CPU Diff Raw (millicores)".
+func rawHeader(header, unit string) string {
+	name, _, _ := strings.Cut(header, " (")
+	return fmt.Sprintf("%s Raw (%s)", name, unit)
+}
+
+// Values for the -diff-format flag
+const (
+	diffSignedRaw = "signed-raw" // the recommendation minus the current value, in millicores or bytes
+	diffAbsolute  = "absolute"   // the magnitude of the diff, without its sign
+	diffDirection = "direction"  // increase, decrease or none
+)
+
+var diffFormats = []string{diffSignedRaw, diffAbsolute, diffDirection}
+
+// diffOf returns the diff output by the diff column with the key, or nil if it isn't a diff column. With totals the diff is
+// multiplied by the workload's replicas, giving the cluster-wide change rather than the change per pod.
+func diffOf(key string, totals bool) func(r collector.ContainerConfig) int64 {
+	var diff func(r collector.ContainerConfig) int64
+	switch key {
+	case "cpuDiff":
+		diff = func(r collector.ContainerConfig) int64 { return r.CurrentConfig.CPUDiff }
+	case "memoryDiff":
+		diff = func(r collector.ContainerConfig) int64 { return r.CurrentConfig.MemDiff }
+	case "cappedCPUDiff":
+		diff = func(r collector.ContainerConfig) int64 { return r.CurrentConfig.CappedCPUDiff }
+	case "cappedMemoryDiff":
+		diff = func(r collector.ContainerConfig) int64 { return r.CurrentConfig.CappedMemDiff }
+	default:
+		return nil
+	}
+
+	if !totals {
+		return diff
+	}
+
+	return func(r collector.ContainerConfig) int64 { return diff(r) * int64(r.CurrentConfig.Replicas) }
+}
+
+// formatDiffs returns the columns with the values of the diff columns formatted according to format. With totals the diffs
+// are multiplied by replicas, and their headers say so.
+func formatDiffs(cols []column, format string, totals bool) []column {
+	formatted := make([]column, 0, len(cols))
+	for _, c := range cols {
+		diff := diffOf(c.key, totals)
+		if diff != nil && totals {
+			c.header = strings.TrimSuffix(c.header, ")") + ", Total Across Replicas)"
+		}
+
+		switch {
+		case diff == nil:
+		case format == diffAbsolute:
+			c.value = func(r collector.ContainerConfig) string { return strconv.FormatInt(absInt(diff(r)), 10) }
+		case format == diffDirection:
+			c.value = func(r collector.ContainerConfig) string { return diffDirectionOf(diff(r)) }
+		default:
+			c.value = func(r collector.ContainerConfig) string { return strconv.FormatInt(diff(r), 10) }
+		}
+		formatted = append(formatted, c)
+	}
+
+	return formatted
+}
+
+// diffDirectionOf returns whether the diff recommends an increase or decrease.
+func diffDirectionOf(diff int64) string {
+	switch {
+	case diff > 0:
+		return "increase"
+	case diff < 0:
+		return "decrease"
+	default:
+		return "none"
+	}
+}
+
+// absInt returns the absolute value of n.
+func absInt(n int64) int64 {
+	if n < 0 {
+		return -n
+	}
+
+	return n
+}
+
+// formatSignedCPU formats millicores in K8s format, with an explicit sign for increases.
+func formatSignedCPU(millicores int64) string {
+	if millicores > 0 {
+		return fmt.Sprintf("+%dm", millicores)
+	}
+
+	return fmt.Sprintf("%dm", millicores)
+}
+
+// formatSignedMemory formats bytes in Mi, matching the other memory columns, with an explicit sign for increases.
+func formatSignedMemory(bytes int64) string {
+	mi := bytes / collector.Mebibyte
+	if mi > 0 {
+		return fmt.Sprintf("+%dMi", mi)
+	}
+
+	return fmt.Sprintf("%dMi", mi)
+}
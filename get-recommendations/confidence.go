@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"get-recommendations/collector"
+)
+
+// Confidence tiers of a recommendation, combining how long the VPA has had to gather usage history with the spread between
+// its bounds. High confidence recommendations can be acted on straight away, low confidence ones are worth waiting on.
+const (
+	confidenceHigh   = "high"
+	confidenceMedium = "medium"
+	confidenceLow    = "low"
+)
+
+var confidenceTiers = []string{confidenceHigh, confidenceMedium, confidenceLow}
+
+// confidenceResultsFile is the base name of the results files written with -split-by-confidence, suffixed with the tier
+const confidenceResultsFile = "results-confidence"
+
+// The VPA's bounds narrow as it gathers history, which takes around 8 days to settle. Workloads younger than a day have
+// barely any, so are low confidence regardless of the bounds.
+const (
+	settledHistory  = 8 * 24 * time.Hour
+	minimumHistory  = 24 * time.Hour
+	highMaxSpread   = 0.5 // (upper-lower)/target
+	mediumMaxSpread = 1.5
+)
+
+// confidenceTier returns the confidence tier of the result: the lower of the tiers from the workload's age and from the
+// widest spread between the bounds of its CPU and memory recommendations. The age is ignored for kinds whose creation time
+// isn't known, and results without a recommendation are low confidence.
+func confidenceTier(r collector.ContainerConfig) string {
+	spread, found := maxSpread(r)
+	if !found {
+		return confidenceLow
+	}
+
+	tier := confidenceHigh
+	switch {
+	case spread > mediumMaxSpread:
+		tier = confidenceLow
+	case spread > highMaxSpread:
+		tier = confidenceMedium
+	}
+
+	if !r.CreatedAt.IsZero() {
+		switch age := time.Since(r.CreatedAt); {
+		case age < minimumHistory:
+			tier = confidenceLow
+		case age < settledHistory && tier == confidenceHigh:
+			tier = confidenceMedium
+		}
+	}
+
+	return tier
+}
+
+// maxSpread returns the larger of the CPU and memory spreads between the bounds relative to the target, (upper-lower)/target.
+// Resources without a recommendation or either bound are left out, returning false if both are.
+func maxSpread(r collector.ContainerConfig) (float64, bool) {
+	spread, found := 0.0, false
+	if r.TargetCPUStr != collector.Pending && r.LowerCPU > 0 && r.UpperCPU > 0 && r.TargetCPU > 0 {
+		spread, found = float64(r.UpperCPU-r.LowerCPU)/float64(r.TargetCPU), true
+	}
+	if r.TargetMemoryStr != collector.Pending && r.LowerMemory > 0 && r.UpperMemory > 0 && r.TargetMemory > 0 {
+		spread, found = max(spread, float64(r.UpperMemory-r.LowerMemory)/float64(r.TargetMemory)), true
+	}
+
+	return spread, found
+}
+
+// writeConfidenceResults writes the results into a file per confidence tier, e.g. results-confidence-high.csv. Every tier's
+// file is written, even if empty, so that a previous run's results aren't left behind.
+func writeConfidenceResults(results []collector.ContainerConfig, opts options) error {
+	byTier := make(map[string][]collector.ContainerConfig, len(confidenceTiers))
+	for _, r := range results {
+		tier := confidenceTier(r)
+		byTier[tier] = append(byTier[tier], r)
+	}
+
+	for _, tier := range confidenceTiers {
+		path := namedResultsPath(fmt.Sprintf("%s-%s", confidenceResultsFile, tier), opts)
+		if err := writeResultsFile(path, byTier[tier], opts); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
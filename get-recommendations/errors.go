@@ -0,0 +1,10 @@
+package main
+
+import "errors"
+
+// ErrNoRecommendations is returned with -fail-if-no-recommendations when none of the targeted VPAs have a recommendation,
+// which usually means the VPA recommender isn't running.
+var ErrNoRecommendations = errors.New("no recommendations found")
+
+// ErrMissingRequests is returned with -only-without-requests and -strict when any container is missing a CPU or memory request.
+var ErrMissingRequests = errors.New("missing CPU or memory requests")
@@ -7,63 +7,291 @@ package main
 
 import (
 	"context"
-	"encoding/csv"
 	"flag"
 	"fmt"
 	"log/slog"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
-	autoscaling "k8s.io/api/autoscaling/v2"
-	v1 "k8s.io/api/core/v1"
-	k8serrors "k8s.io/apimachinery/pkg/api/errors"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	verticalAutoscalingClientSet "k8s.io/autoscaler/vertical-pod-autoscaler/pkg/client/clientset/versioned"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/client-go/util/homedir"
+
+	"get-recommendations/collector"
 )
 
-const resultsFile = "results.csv"
-
-type containerConfig struct {
-	namespace       string
-	resourceType    string
-	resourceName    string
-	containerName   string
-	vpaName         string
-	targetCPUStr    string
-	targetMemoryStr string
-	currentConfig   resourceDrift
-	hasHPA          bool
-}
+// resultsFile is the base name of the results file, which is suffixed with the extension of the output format
+const resultsFile = "results"
+
+// Base names of the results files written with -split-by-direction
+const (
+	increaseResultsFile = "results-increase"
+	decreaseResultsFile = "results-decrease"
+)
 
-type resourceDrift struct {
-	currentCPUStr string
-	currentMemStr string
-	currentCPU    int64
-	currentMem    int64
-	cpuDiff       int64
-	memDiff       int64
+// schemaVersion is written as a comment line at the top of the results so that consumers can detect format changes.
+// Bump it whenever columns are added, removed or reordered.
+const schemaVersion = 20
+
+// compareMixed is reported when summed containers were compared on different bases, matched different container types or
+// belong to VPAs with different managers
+const compareMixed = "mixed"
+
+// options holds the behaviour selected via the command line flags
+type options struct {
+	collector.Options
+
+	checkQuotas     bool
+	outputURL       string
+	previousFile    string
+	gzip            bool
+	format          string
+	markdownRows    int
+	containerSum    bool
+	columns         []column // selected via -output-fields
+	explain         bool
+	outputSQLite    string
+	annotate        bool
+	summary         bool
+	color           bool
+	patches         bool
+	patchMode       string
+	noHeader        bool
+	helmValuesPath  string
+	splitDirection  bool
+	splitConfidence bool
+	meta            runMetadata // written alongside the results
+	failIfEmpty     bool
+	groupBy         string
+	maxResults      int
+	sortBy          string
+	apply           bool
+	dryRun          bool
+	assumeYes       bool
+	namespaceDir    string  // directory to also write a results file per namespace to, if set
+	alertThreshold  float64 // relative drift for the -prometheus-rules alerts, zero if not generating them
+
+	missingNamespaces   []string // requested namespaces which don't exist, reported in the summary
+	onlyWithoutRequests bool
+	csvBOM              bool
+	strict              bool
 }
 
 func main() {
-	l, err := getLogger()
+	// Registered from init functions, so only complete once main runs
+	columns = append(columns, extraColumns()...)
+
+	var opts options
+	n := flag.String("namespaces", "", "comma separated list of namespaces to query")
+	watch := flag.Bool("watch", false, "continuously refresh the recommendations, rewriting the results file each cycle")
+	interval := flag.Duration("interval", time.Minute, "how often to refresh the recommendations when running with -watch")
+	healthAddr := flag.String("health-addr", "", "address to serve /healthz and /readyz on, e.g. :8080. Disabled by default")
+	flag.BoolVar(&opts.checkQuotas, "check-quotas", false, fmt.Sprintf("compare the recommended requests summed per namespace against any ResourceQuotas, writing the outcome to %s", quotaReportFile))
+	flag.StringVar(&opts.CompareAgainst, "compare-against", collector.CompareRequests, fmt.Sprintf("current container config to diff the recommendations against. One of %s or %s. With %s, the requests are still used when set", collector.CompareRequests, collector.CompareLimits, collector.CompareLimits))
+	flag.StringVar(&opts.outputURL, "output-url", "", "optional s3://bucket/path to upload the results to, in addition to writing them locally")
+	flag.StringVar(&opts.previousFile, "previous", "", "optional results CSV from an earlier run, used to report how each recommendation has changed since")
+	flag.BoolVar(&opts.IncludePending, "include-pending", false, fmt.Sprintf("emit a placeholder row marked %s for VPAs which don't have any per-container recommendations yet", collector.Pending))
+	flag.BoolVar(&opts.gzip, "gzip", false, "gzip compress the results, appending .gz to the filename")
+	flag.StringVar(&opts.format, "format", formatCSV, fmt.Sprintf("output format. One of %s", strings.Join(outputFormats, ", ")))
+	flag.IntVar(&opts.markdownRows, "markdown-rows", 0, "limit the markdown table to the N rows with the largest drift. 0 includes every row")
+	flag.BoolVar(&opts.IncludeSystemNamespaces, "include-system-namespaces", false, fmt.Sprintf("include the system namespaces (%s) when querying every namespace", strings.Join(collector.SystemNamespaces, ", ")))
+	flag.BoolVar(&opts.IncludeTerminating, "include-terminating-namespaces", false, "include namespaces which are being deleted, whose workloads are only partially listed")
+	flag.StringVar(&opts.TeamLabel, "team-label", "", "label key whose value is reported in the team column. Read from the workload, falling back to its namespace")
+	kubeconfigData := flag.String("kubeconfig-data", "", fmt.Sprintf("raw kubeconfig contents to use instead of ~/.kube/config. Defaults to the %s env var", kubeconfigDataEnv))
+	flag.BoolVar(&opts.containerSum, "container-sum", false, fmt.Sprintf("sum the recommendations and current requests across all containers, emitting one row per workload with a container name of %s", allContainers))
+	outputFields := flag.String("output-fields", "", fmt.Sprintf("comma separated list of the columns to output, in order. Defaults to all of: %s", strings.Join(columnKeys(), ",")))
+	flag.DurationVar(&opts.MinWorkloadAge, "min-workload-age", 0, "skip workloads created more recently than this, e.g. 1h, as their recommendations won't be meaningful yet. 0 disables the check")
+	flag.BoolVar(&opts.explain, "explain", false, fmt.Sprintf("write a row to %s for every VPA which was skipped, with the reason", skippedReportFile))
+	registerSQLiteFlag(&opts)
+	flag.BoolVar(&opts.LivePodsWhenMutated, "live-pods-when-auto", false, "for VPAs in Auto or Recreate update mode, compare against the requests of a running pod rather than the workload's pod template, as the VPA will have mutated them")
+	insecure := flag.Bool("insecure-skip-tls-verify", false, "don't verify the API server's certificate. Insecure, only use against lab clusters")
+	caFile := flag.String("ca-file", "", "CA bundle to verify the API server's certificate with, for kubeconfigs which don't embed the CA")
+	flag.BoolVar(&opts.annotate, "annotate-workloads", false, fmt.Sprintf("record the recommended targets on each workload as the %s and %s annotations", cpuAnnotation, memoryAnnotation))
+	flag.BoolVar(&opts.summary, "summary", false, "also print a summary of the drift of each container to stdout")
+	flag.BoolVar(&opts.color, "color", false, "color the drift figures of the -summary by how far out of range they are. Colors are only applied when stdout is a terminal")
+	diffFormat := flag.String("diff-format", diffSignedRaw, fmt.Sprintf("format of the diff columns. One of %s (the recommendation minus the current value), %s (the magnitude only) or %s (increase, decrease or none)", diffSignedRaw, diffAbsolute, diffDirection))
+	totals := flag.Bool("totals", false, "multiply the diff columns by the replicas column, reporting the cluster-wide change for each workload rather than the change per pod")
+	human := flag.Bool("human", false, "format the diff columns with units, e.g. -256Mi or +150m, adding cpuDiffRaw and memoryDiffRaw columns with the raw values")
+	flag.BoolVar(&opts.ClusterList, "cluster-list", false, "list the VPAs across every namespace in a single API call, rather than one per namespace. Ignored with -namespaces")
+	namespacesFile := flag.String("namespaces-file", "", "file listing namespaces to target, one per line. Blank lines and # comments are ignored. Merged with -namespaces")
+	flag.BoolVar(&opts.patches, "patches", false, fmt.Sprintf("also write a strategic merge patch per workload to %s, setting its containers' resources to the recommendations", patchesFile))
+	flag.StringVar(&opts.patchMode, "patch-mode", patchRequests, fmt.Sprintf("resources set by the -patches. One of %s, or %s which scales any limits to preserve the existing request:limit ratio", patchRequests, patchRequestsAndLimits))
+	flag.BoolVar(&opts.noHeader, "no-header", false, "leave the schema version comment and header row out of the CSV, e.g. when appending to an existing dataset")
+	flag.StringVar(&opts.helmValuesPath, "helm-values-path", "resources", fmt.Sprintf("dotted path of the chart's resources value, used with -format=%s. %s is replaced with the container name, e.g. %s.resources", formatHelm, containerPlaceholder, containerPlaceholder))
+	flag.BoolVar(&opts.splitDirection, "split-by-direction", false, fmt.Sprintf("also write the containers recommended more resources to %s, and those recommended less to %s", increaseResultsFile, decreaseResultsFile))
+	flag.BoolVar(&opts.splitConfidence, "split-by-confidence", false, fmt.Sprintf("also write the containers into a file per confidence tier (%s), e.g. %s-%s, from the workload's age and the spread between the VPA's bounds", strings.Join(confidenceTiers, ", "), confidenceResultsFile, confidenceHigh))
+	flag.BoolVar(&opts.failIfEmpty, "fail-if-no-recommendations", false, "exit non-zero if none of the targeted VPAs have a recommendation, e.g. because the VPA recommender has crashed")
+	flag.StringVar(&opts.groupBy, "group-by", "", fmt.Sprintf("set to %s to roll the results up to each workload's top-level controller, e.g. the Deployment owning a ReplicaSet. The recommendations stay per pod, averaged across its children weighted by their replicas, which are summed", groupByOwner))
+	prometheusRules := flag.Bool("prometheus-rules", false, fmt.Sprintf("also write a PrometheusRule to %s, alerting when the VPA target of each container drifts from its requests by more than -alert-threshold", prometheusRulesFile))
+	alertThreshold := flag.Float64("alert-threshold", largeDrift, "relative drift from the requests at which the -prometheus-rules alerts fire, e.g. 0.5 for 50%")
+	flag.IntVar(&opts.maxResults, "max-results", 0, "only keep the top N results according to -sort-by, e.g. to tackle the worst offenders first. 0 keeps every result")
+	flag.StringVar(&opts.sortBy, "sort-by", sortDrift, fmt.Sprintf("criterion used to rank the results kept by -max-results. One of %s", strings.Join(sortCriteria, ", ")))
+	flag.BoolVar(&opts.apply, "apply", false, "patch the requests of each workload's containers to the recommendations, after confirmation. CPU is left unchanged for workloads with an HPA scaling on CPU. Honours -patch-mode")
+	flag.BoolVar(&opts.dryRun, "dry-run", false, fmt.Sprintf("with -apply, only validate the patches via a server-side dry run, without changing anything or asking for confirmation. The old and new requests of each container, and whether admission rejected the patch, are written to %s", dryRunReportFile))
+	flag.BoolVar(&opts.assumeYes, "yes", false, "with -apply, don't ask for confirmation")
+	flag.StringVar(&opts.namespaceDir, "output-per-namespace", "", fmt.Sprintf("also write a %s-<namespace> results file per namespace into this directory, e.g. to hand each team just their rows", resultsFile))
+	flag.StringVar(&opts.RecommendationSource, "recommendation-source", collector.SourceTarget, fmt.Sprintf("bound of the VPA recommendation used as the recommended value in the diffs, patches and other outputs. One of %s", strings.Join(collector.RecommendationSources, ", ")))
+	flag.StringVar(&opts.CompareLivePods, "compare-live-pods", "", fmt.Sprintf("compare against the %s or %s requests across the workload's running pods rather than its pod template, e.g. to catch rollouts in progress", collector.LiveAverage, collector.LiveMax))
+	denylist := flag.String("global-container-denylist", "", "comma separated list of container names, such as istio-proxy, to leave out of the results in every workload")
+	cappedDiffs := flag.Bool("capped-diffs", false, "also output the capped VPA target (after the resource policy's minAllowed/maxAllowed) and its diffs from the current requests, alongside the uncapped ones, to show how much the policy constrains the recommendations")
+	flag.IntVar(&opts.WorkersPerNamespace, "workers-per-namespace", 1, "number of VPAs within a namespace to process in parallel, each fetching its workload. The output order is unaffected")
+	skipOperatorManaged := flag.Bool("skip-operator-managed", false, "skip workloads carrying any of the -operator-labels, as operators such as Strimzi manage their own requests and fight the VPA")
+	operatorLabels := flag.String("operator-labels", strings.Join(collector.DefaultOperatorLabels, ","), "comma separated list of label keys, or key=value pairs, identifying operator managed workloads for -skip-operator-managed")
+	flag.Float64Var(&opts.CPUMultiplier, "cpu-multiplier", 1, fmt.Sprintf("multiplier applied to the CPU recommendations, e.g. 1.2 for 20%% headroom. Overridden per workload by the %s annotation", collector.CPUMultiplierAnnotation))
+	flag.Float64Var(&opts.MemoryMultiplier, "memory-multiplier", 1, fmt.Sprintf("multiplier applied to the memory recommendations, rounded up to the next Mi. Overridden per workload by the %s annotation", collector.MemoryMultiplierAnnotation))
+	flag.BoolVar(&opts.csvBOM, "csv-bom", false, "start the CSV with a UTF-8 byte order mark, so that Excel reads it as UTF-8. Off by default as it can trip up other parsers")
+	minCurrentCPU := flag.String("min-current-cpu", "", "skip containers whose current CPU request is below this, e.g. 50m, as not worth rightsizing. With -min-current-memory, only those below both are skipped")
+	minCurrentMem := flag.String("min-current-memory", "", "skip containers whose current memory request is below this, e.g. 64Mi, as not worth rightsizing. With -min-current-cpu, only those below both are skipped")
+	extraLabels := flag.String("extra-label-columns", "", "comma separated list of workload label keys, e.g. app,tier, to output as extra columns named after each key. Empty for workloads without the label")
+	flag.BoolVar(&opts.onlyWithoutRequests, "only-without-requests", false, fmt.Sprintf("only report the containers without a CPU or memory request (%s), e.g. to audit that every workload sets requests. With -strict the run fails if there are any", collector.NotSet))
+	flag.BoolVar(&opts.DedupeContainers, "dedupe-containers", false, "where a pod template lists the same container name more than once, compare against the one with the larger requests rather than the first")
+	listUnsupported := flag.Bool("list-unsupported-targets", false, fmt.Sprintf("rather than collecting the recommendations, write the VPAs targeting a kind other than %s to %s and exit, e.g. to find VPAs for custom resources", strings.Join(collector.SupportedKinds, ", "), unsupportedTargetsFile))
+	flag.BoolVar(&opts.strict, "strict", false, "fail rather than warn when a namespace passed via -namespaces or -namespaces-file doesn't exist. With -only-without-requests, also fail if any container is missing a request")
+	quiet := flag.Bool("quiet", false, "only log warnings and errors, regardless of LOG_LEVEL")
+	flag.Parse()
+
+	l, err := getLogger(*quiet)
 	if err != nil {
 		panic(err)
 	}
 
-	var namespaces []string
-	n := flag.String("namespaces", "", "comma separated list of namespaces to query")
-	flag.Parse()
+	opts.columns, err = selectColumns(*outputFields)
+	if err != nil {
+		panic(err.Error())
+	}
+	if *cappedDiffs {
+		if opts.RecommendationSource != collector.SourceTarget {
+			panic("-capped-diffs can only be used with -recommendation-source=target")
+		}
+		opts.columns = append(slices.Clone(opts.columns), cappedColumns...)
+	}
+	if *minCurrentCPU != "" {
+		q, err := resource.ParseQuantity(*minCurrentCPU)
+		if err != nil {
+			panic(fmt.Sprintf("invalid -min-current-cpu: %v", err))
+		}
+		opts.MinCurrentCPU = q.MilliValue()
+	}
+	if *minCurrentMem != "" {
+		q, err := resource.ParseQuantity(*minCurrentMem)
+		if err != nil {
+			panic(fmt.Sprintf("invalid -min-current-memory: %v", err))
+		}
+		opts.MinCurrentMem = q.Value()
+	}
+	if *extraLabels != "" {
+		opts.columns = append(slices.Clone(opts.columns), labelColumns(strings.Split(*extraLabels, ","))...)
+	}
+	if !slices.Contains(diffFormats, *diffFormat) {
+		panic(fmt.Sprintf("-diff-format must be one of %s", strings.Join(diffFormats, ", ")))
+	}
+	if *human && *diffFormat != diffSignedRaw {
+		panic("-human can't be used with -diff-format")
+	}
+	opts.columns = formatDiffs(opts.columns, *diffFormat, *totals)
+	if *human {
+		opts.columns = humanizeDiffs(opts.columns, *totals)
+	}
+	if !slices.Contains(outputFormats, opts.format) {
+		panic(fmt.Sprintf("-format must be one of %s", strings.Join(outputFormats, ", ")))
+	}
+	var setFlags []string
+	flag.Visit(func(f *flag.Flag) { setFlags = append(setFlags, f.Name) })
+	if err := validateFormatFlags(opts.format, setFlags); err != nil {
+		panic(err.Error())
+	}
+	if opts.helmValuesPath == "" {
+		panic("-helm-values-path must not be empty")
+	}
+	if opts.patchMode != patchRequests && opts.patchMode != patchRequestsAndLimits {
+		panic(fmt.Sprintf("-patch-mode must be one of %s or %s", patchRequests, patchRequestsAndLimits))
+	}
+	if *prometheusRules {
+		if *alertThreshold <= 0 {
+			panic("-alert-threshold must be greater than zero")
+		}
+		opts.alertThreshold = *alertThreshold
+	}
+	if opts.apply && *watch {
+		panic("-apply can't be used with -watch")
+	}
+	if *listUnsupported && (*watch || opts.apply) {
+		panic("-list-unsupported-targets can't be used with -watch or -apply")
+	}
+	if opts.color && !opts.summary {
+		panic("-color requires -summary")
+	}
+	if (opts.dryRun || opts.assumeYes) && !opts.apply {
+		panic("-dry-run and -yes require -apply")
+	}
+	if !slices.Contains(collector.RecommendationSources, opts.RecommendationSource) {
+		panic(fmt.Sprintf("-recommendation-source must be one of %s", strings.Join(collector.RecommendationSources, ", ")))
+	}
+	if opts.CompareLivePods != "" && opts.CompareLivePods != collector.LiveAverage && opts.CompareLivePods != collector.LiveMax {
+		panic(fmt.Sprintf("-compare-live-pods must be empty, %s or %s", collector.LiveAverage, collector.LiveMax))
+	}
+	if opts.maxResults < 0 {
+		panic("-max-results must not be negative")
+	}
+	if opts.CPUMultiplier <= 0 || opts.MemoryMultiplier <= 0 {
+		panic("-cpu-multiplier and -memory-multiplier must be greater than zero")
+	}
+	if opts.WorkersPerNamespace < 1 {
+		panic("-workers-per-namespace must be at least 1")
+	}
+	if !slices.Contains(sortCriteria, opts.sortBy) {
+		panic(fmt.Sprintf("-sort-by must be one of %s", strings.Join(sortCriteria, ", ")))
+	}
+	if opts.groupBy != "" && opts.groupBy != groupByOwner {
+		panic(fmt.Sprintf("-group-by must be empty or %s", groupByOwner))
+	}
+	opts.ResolveOwners = opts.groupBy == groupByOwner
+	if opts.CompareAgainst != collector.CompareRequests && opts.CompareAgainst != collector.CompareLimits {
+		panic(fmt.Sprintf("-compare-against must be one of %s or %s", collector.CompareRequests, collector.CompareLimits))
+	}
+	if *skipOperatorManaged {
+		opts.OperatorLabels, err = collector.ParseOperatorLabels(*operatorLabels)
+		if err != nil {
+			panic(err.Error())
+		}
+	}
+	if *denylist != "" {
+		opts.ContainerDenylist = strings.Split(*denylist, ",")
+	}
 	if *n != "" {
-		namespaces = strings.Split(*n, ",")
-		l.Info("Targeting specific namespaces", "namespaces", *n)
+		opts.Namespaces = strings.Split(*n, ",")
+	}
+	if *namespacesFile != "" {
+		opts.Namespaces, err = readNamespacesFile(*namespacesFile, opts.Namespaces)
+		if err != nil {
+			panic(err.Error())
+		}
+	}
+	if len(opts.Namespaces) > 0 {
+		l.Info("Targeting specific namespaces", "namespaces", strings.Join(opts.Namespaces, ","))
+	}
+	if *interval <= 0 {
+		panic("-interval must be greater than zero")
+	}
+	if opts.MinWorkloadAge < 0 {
+		panic("-min-workload-age must not be negative")
 	}
 
-	config, err := clientcmd.BuildConfigFromFlags("", filepath.Join(homedir.HomeDir(), ".kube", "config"))
+	config, err := buildConfig(*kubeconfigData)
+	if err != nil {
+		panic(err.Error())
+	}
+	opts.meta = newRunMetadata(config.Host, *kubeconfigData)
+	err = applyTLSOptions(config, *insecure, *caFile, l)
 	if err != nil {
 		panic(err.Error())
 	}
@@ -78,253 +306,396 @@ func main() {
 		panic(err.Error())
 	}
 
-	if len(namespaces) == 0 {
-		namespaces, err = getNamespaces(clientset)
+	// Cancelled on Ctrl-C so that in-flight API calls and the watch loop stop cleanly
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if len(opts.Namespaces) > 0 {
+		requested := opts.Namespaces
+		opts.Namespaces, err = validateNamespaces(ctx, clientset, opts.Namespaces, opts.strict, opts.IncludeTerminating, l)
 		if err != nil {
 			panic(err.Error())
 		}
+		for _, namespace := range requested {
+			if !slices.Contains(opts.Namespaces, namespace) {
+				opts.missingNamespaces = append(opts.missingNamespaces, namespace)
+			}
+		}
 	}
 
-	results := make([]containerConfig, 0)
-
-	for _, namespace := range namespaces {
-
-		l.Debug("Processing namespace", "namespace", namespace)
-
-		// Get HPA targets for this namespace
-		hasHPAMapping, err := hpaMappings(clientset, namespace)
+	if *listUnsupported {
+		count, err := writeUnsupportedTargetsReport(ctx, clientset, vpaClient, opts, l)
 		if err != nil {
 			panic(err.Error())
 		}
+		l.Info("VPAs targeting unsupported kinds", "count", count, "report", unsupportedTargetsFile)
+		return
+	}
+
+	var health *healthServer
+	if *healthAddr != "" {
+		health = newHealthServer(*healthAddr)
+		health.start(ctx, l)
+	}
 
-		vpas, err := vpaClient.AutoscalingV1().VerticalPodAutoscalers(namespace).List(context.TODO(), metav1.ListOptions{})
+	if !*watch {
+		err = run(ctx, clientset, vpaClient, opts, l)
 		if err != nil {
 			panic(err.Error())
 		}
-		l.Debug("Found VPAs in namespace", "numVPAs", len(vpas.Items), "namespace", namespace)
+		return
+	}
 
-		for _, vpa := range vpas.Items {
+	l.Info("Watching recommendations", "interval", interval.String())
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
 
-			// Skip VPA if the target resource does not exist
-			exists, err := resourceExists(vpa.Spec.TargetRef.Name, vpa.Spec.TargetRef.Kind, namespace, clientset)
-			if err != nil {
-				panic(err.Error())
-			}
-			if !exists {
-				l.Info("target does not exist. Skipping", "namespace", namespace, "vpa", vpa.Name, "resourceType", vpa.Spec.TargetRef.Kind, "resourceName", vpa.Spec.TargetRef.Name)
-				continue
-			}
+	for {
+		// Errors are logged rather than fatal so that a transient API failure doesn't end the watch session
+		err = run(ctx, clientset, vpaClient, opts, l)
+		if err != nil && ctx.Err() == nil {
+			l.Error("Error refreshing recommendations", "error", err)
+		}
+		if err == nil && health != nil {
+			health.markReady()
+		}
 
-			if len(vpa.Status.Recommendation.ContainerRecommendations) == 0 {
-				l.Info("Skipping as there are no recommendations. The resource may have a VPA unsupported parent controller such as SeldonDeployment", "namespace", namespace, "vpa", vpa.Name, "resourceType", vpa.Spec.TargetRef.Kind, "resourceName", vpa.Spec.TargetRef.Name)
-			}
+		select {
+		case <-ctx.Done():
+			l.Info("Stopping watch")
+			return
+		case <-ticker.C:
+		}
+	}
+}
 
-			for _, containerRecommendation := range vpa.Status.Recommendation.ContainerRecommendations {
-
-				// Get uncapped memory recommendation and store in K8s format converted to MB
-				t1 := containerRecommendation.UncappedTarget["memory"]
-				memoryTargetBytes := t1.Value()
-				memoryTargetMB := memoryTargetBytes / 1024 / 1024
-				memoryTarget := fmt.Sprintf("%dMi", memoryTargetMB)
-
-				// Get uncapped CPU recommendation. It's already in the correct K8s format
-				t2 := containerRecommendation.UncappedTarget["cpu"]
-				cpuTargetStr := t2.String()
-				cpuTargetRaw := t2.MilliValue()
-
-				// Get the current container resource config and calculate the diff from the recommendation
-				resourceConfig, err := currentResourceConfig(vpa.Spec.TargetRef.Name, vpa.Spec.TargetRef.Kind, containerRecommendation.ContainerName, namespace, clientset, l)
-				if err != nil {
-					panic(err.Error())
-				}
-
-				r := containerConfig{
-					namespace:       namespace,
-					resourceType:    vpa.Spec.TargetRef.Kind,
-					resourceName:    vpa.Spec.TargetRef.Name,
-					containerName:   containerRecommendation.ContainerName,
-					vpaName:         vpa.Name,
-					targetCPUStr:    cpuTargetStr,
-					targetMemoryStr: memoryTarget,
-					currentConfig:   resourceConfig,
-				}
-
-				if resourceConfig.currentCPUStr != "NOT_SET" {
-					r.currentConfig.cpuDiff = cpuTargetRaw - resourceConfig.currentCPU
-				}
-
-				if resourceConfig.currentMemStr != "NOT_SET" {
-					r.currentConfig.memDiff = memoryTargetBytes - resourceConfig.currentMem
-				}
-
-				for _, hpa := range hasHPAMapping {
-					if strings.ToLower(hpa.Kind) == strings.ToLower(r.resourceType) && strings.ToLower(hpa.Name) == strings.ToLower(r.resourceName) {
-						r.hasHPA = true
-					}
-				}
-
-				l.Debug("Container resourceConfig", "container", r.containerName, "currentCPURaw", resourceConfig.currentCPU, "currentMemoryRaw", resourceConfig.currentMem, "recommendedMemory", memoryTargetBytes, "recommendedCPU", cpuTargetRaw, "hasHPA", r.hasHPA)
-
-				results = append(results, r)
+// run performs a single collection cycle and writes the results file.
+// If no namespaces are passed then every namespace in the cluster is queried, which is re-evaluated each call.
+func run(ctx context.Context, clientset *kubernetes.Clientset, vpaClient *verticalAutoscalingClientSet.Clientset, opts options, l *slog.Logger) error {
+	c := collector.NewCollector(clientset, vpaClient, opts.Options, l)
+	results, err := c.Collect(ctx)
+	namespaces, skipped := c.Namespaces(), c.Skipped()
+	if err != nil {
+		// Salvages the namespaces completed before Ctrl-C, rather than losing all the work of a long run
+		if ctx.Err() != nil && len(results) > 0 {
+			if err := writePartialResults(results, opts, l); err != nil {
+				l.Error("Error writing partial results", "error", err)
 			}
 		}
+		return err
+	}
+
+	// Orphaned VPAs are worth cleaning up, so are reported even without -explain
+	if missing := countSkipped(skipped, collector.SkipTargetNotFound); missing > 0 {
+		l.Warn("VPAs targeting resources which don't exist in their namespace. Run with -explain to list them", "count", missing)
+	}
+
+	if opts.explain {
+		l.Info("Skipped VPAs", "count", len(skipped), "report", skippedReportFile, "missingTargetsReport", missingTargetsFile)
+		err = writeSkippedReport(skipped)
+		if err != nil {
+			return err
+		}
+		err = writeMissingTargetsReport(skipped)
+		if err != nil {
+			return err
+		}
 	}
 
 	l.Info("Container recommendation results", "count", len(results))
 
-	err = writeResults(results)
-	if err != nil {
-		panic(err.Error())
+	// Checked before writing so that a broken recommender doesn't replace the previous results with an empty file
+	if opts.failIfEmpty && countRecommendations(results) == 0 {
+		return fmt.Errorf("scanning %d namespaces: %w", len(namespaces), ErrNoRecommendations)
 	}
-}
 
-// hpaMappings returns a slice containing the targets of every HPA in a namespace
-func hpaMappings(clientset *kubernetes.Clientset, namespace string) ([]autoscaling.CrossVersionObjectReference, error) {
-	hpas, err := clientset.AutoscalingV2().HorizontalPodAutoscalers(namespace).List(context.TODO(), metav1.ListOptions{})
-	if err != nil {
-		return nil, fmt.Errorf("error getting HPAs: %v", err)
+	// Only the NOT_SET rows, for auditing that every workload sets requests
+	var unrequested int
+	if opts.onlyWithoutRequests {
+		results = withoutRequests(results)
+		unrequested = len(results)
+		l.Info("Containers without a CPU or memory request", "count", unrequested)
 	}
-	hasHPAMapping := make([]autoscaling.CrossVersionObjectReference, 0, len(hpas.Items))
-	for _, hpa := range hpas.Items {
-		hasHPAMapping = append(hasHPAMapping, hpa.Spec.ScaleTargetRef)
+
+	// Done before summing, as the annotations, patches and alerts are per container
+	if opts.annotate {
+		err = annotateWorkloads(ctx, clientset, results, l)
+		if err != nil {
+			return err
+		}
 	}
 
-	return hasHPAMapping, nil
-}
+	if opts.patches {
+		err = writePatches(buildPatches(results, opts.patchMode, l))
+		if err != nil {
+			return err
+		}
+	}
 
-func currentResourceConfig(resourceName, resourceType, containerName, namespace string, client *kubernetes.Clientset, logger *slog.Logger) (resourceDrift, error) {
-	d := resourceDrift{}
+	if opts.apply {
+		err = applyRecommendations(ctx, clientset, results, opts, l)
+		if err != nil {
+			return err
+		}
+	}
 
-	switch resourceType {
-	case "Deployment":
-		deployment, err := client.AppsV1().Deployments(namespace).Get(context.TODO(), resourceName, metav1.GetOptions{})
+	if opts.alertThreshold > 0 {
+		err = writePrometheusRules(results, opts.alertThreshold)
 		if err != nil {
-			return d, fmt.Errorf("error getting deployment %s/%s: %v", namespace, resourceName, err)
+			return err
 		}
-		d = getContainerResourceConfig(deployment.Spec.Template.Spec.Containers, containerName, logger)
+	}
 
-	case "StatefulSet":
-		statefulset, err := client.AppsV1().StatefulSets(namespace).Get(context.TODO(), resourceName, metav1.GetOptions{})
+	if opts.groupBy == groupByOwner {
+		results = groupResultsByOwner(results)
+	}
+
+	if opts.containerSum {
+		results = sumContainers(results)
+	}
+
+	if opts.previousFile != "" {
+		previous, err := loadPreviousResults(opts.previousFile)
 		if err != nil {
-			return d, fmt.Errorf("error getting statefuleset %s/%s: %v", namespace, resourceName, err)
+			return err
 		}
-		d = getContainerResourceConfig(statefulset.Spec.Template.Spec.Containers, containerName, logger)
+		applyPreviousResults(results, previous)
+	}
+
+	// Errors from optional checks are recorded in the summary rather than failing the run
+	warnings := make([]error, 0)
 
-	case "DaemonSet":
-		daemonset, err := client.AppsV1().DaemonSets(namespace).Get(context.TODO(), resourceName, metav1.GetOptions{})
+	err = flagUnschedulableMemory(ctx, clientset, results, l)
+	if err != nil {
+		l.Warn("Unable to check recommendations against node allocatable memory", "error", err)
+		warnings = append(warnings, fmt.Errorf("checking node allocatable memory: %w", err))
+	}
+
+	if opts.checkQuotas {
+		err = checkQuotas(ctx, clientset, results, l)
 		if err != nil {
-			return d, fmt.Errorf("error getting daemonsets %s/%s: %v", namespace, resourceName, err)
+			return err
 		}
-		d = getContainerResourceConfig(daemonset.Spec.Template.Spec.Containers, containerName, logger)
 	}
 
-	return d, nil
-}
+	collector.ApplyResultProcessors(results)
+	logKindStats(results, l)
 
-func getContainerResourceConfig(containers []v1.Container, containerName string, _ *slog.Logger) resourceDrift {
-	d := resourceDrift{}
-
-	for _, container := range containers {
-		if strings.ToLower(container.Name) == strings.ToLower(containerName) {
-			cpu := container.Resources.Requests.Cpu().MilliValue()
-			if cpu == 0 {
-				d.currentCPUStr = "NOT_SET"
-			} else {
-				d.currentCPUStr = fmt.Sprintf("%dm", cpu)
-				d.currentCPU = container.Resources.Requests.Cpu().MilliValue()
-			}
+	// Summarised before any truncation, so the totals cover every result
+	summary := newRunSummary(results, skipped, namespaces, opts.missingNamespaces, warnings)
 
-			mem := fmt.Sprintf("%dMi", container.Resources.Requests.Memory().Value()/1024/1024)
-			if mem == "0Mi" {
-				d.currentMemStr = "NOT_SET"
-			} else {
-				d.currentMemStr = mem
-				d.currentMem = container.Resources.Requests.Memory().Value()
-			}
+	if opts.maxResults > 0 {
+		var truncated int
+		results, truncated = topResults(results, opts.maxResults, opts.sortBy)
+		if truncated > 0 {
+			l.Info("Truncated results to the top offenders", "kept", len(results), "truncated", truncated, "sortBy", opts.sortBy)
+		}
+	}
+
+	err = writeResults(results, opts)
+	if err != nil {
+		return err
+	}
 
-			break
+	if opts.splitDirection {
+		err = writeDirectionResults(results, opts)
+		if err != nil {
+			return err
 		}
 	}
 
-	return d
-}
+	if opts.splitConfidence {
+		err = writeConfidenceResults(results, opts)
+		if err != nil {
+			return err
+		}
+	}
+
+	if opts.namespaceDir != "" {
+		err = writeNamespaceResults(results, opts.namespaceDir, opts)
+		if err != nil {
+			return err
+		}
+	}
+
+	runAt := time.Now()
+	err = writeRunMetadata(opts.meta, runAt)
+	if err != nil {
+		return err
+	}
 
-func resourceExists(resourceName, resourceType, namespace string, client *kubernetes.Clientset) (bool, error) {
-	switch resourceType {
-	case "Deployment":
-		_, err := client.AppsV1().Deployments(namespace).Get(context.TODO(), resourceName, metav1.GetOptions{})
-		if k8serrors.IsNotFound(err) {
-			return false, nil
-		} else if err != nil {
-			return false, fmt.Errorf("error getting deployment %s (%s): %v", resourceName, namespace, err)
+	err = writeRunSummary(summary, runAt)
+	if err != nil {
+		return err
+	}
+
+	if opts.summary {
+		err = writeSummary(os.Stdout, results, opts.color && isTerminal(os.Stdout))
+		if err != nil {
+			return err
 		}
+	}
 
-	case "StatefulSet":
-		_, err := client.AppsV1().StatefulSets(namespace).Get(context.TODO(), resourceName, metav1.GetOptions{})
-		if k8serrors.IsNotFound(err) {
-			return false, nil
-		} else if err != nil {
-			return false, fmt.Errorf("error getting statefuleset %s (%s): %v", resourceName, namespace, err)
+	if opts.outputSQLite != "" {
+		err = writeSQLite(results, opts.outputSQLite, runAt)
+		if err != nil {
+			return err
 		}
+	}
 
-	case "DaemonSet":
-		_, err := client.AppsV1().DaemonSets(namespace).Get(context.TODO(), resourceName, metav1.GetOptions{})
-		if k8serrors.IsNotFound(err) {
-			return false, nil
-		} else if err != nil {
-			return false, fmt.Errorf("error getting daemonset %s (%s): %v", resourceName, namespace, err)
+	if opts.outputURL != "" {
+		err = uploadFile(ctx, opts.outputURL, resultsPath(opts), l)
+		if err != nil {
+			return err
 		}
 	}
 
-	return true, nil
+	// Checked last, so that the offending containers are still written out as a report
+	if opts.onlyWithoutRequests && opts.strict && unrequested > 0 {
+		return fmt.Errorf("%d containers: %w", unrequested, ErrMissingRequests)
+	}
+
+	return nil
+}
+
+// formatAge returns the time since t in the largest whole unit, e.g. 12d, 5h or 30m. Empty if t is zero.
+func formatAge(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+
+	age := time.Since(t)
+	switch {
+	case age >= 24*time.Hour:
+		return fmt.Sprintf("%dd", int(age.Hours()/24))
+	case age >= time.Hour:
+		return fmt.Sprintf("%dh", int(age.Hours()))
+	default:
+		return fmt.Sprintf("%dm", int(age.Minutes()))
+	}
 }
 
-func writeResults(results []containerConfig) error {
-	// csv package expects a slice of string slices. Each slice is a CSV row
-	csvSource := make([][]string, 0, len(results))
-	csvSource = append(csvSource, []string{"namespace", "resourceType", "resourceName", "containerName", "VPA Target CPU", "VPA Target Memory", "Current CPU Requests", "Current Memory Requests", "CPU Diff (VPA-Current)", "Memory Diff (VPA-Current)", "HPA Enabled"})
-	for _, r := range results {
-		csvSource = append(csvSource, []string{r.namespace, r.resourceType, r.resourceName, r.containerName, r.targetCPUStr, r.targetMemoryStr, r.currentConfig.currentCPUStr, r.currentConfig.currentMemStr, fmt.Sprintf("%d", r.currentConfig.cpuDiff), fmt.Sprintf("%d", r.currentConfig.memDiff), fmt.Sprintf("%t", r.hasHPA)})
+// formatOptionalBool returns an empty string for nil, for checks which could not be performed.
+func formatOptionalBool(b *bool) string {
+	if b == nil {
+		return ""
 	}
 
-	_ = os.Remove(resultsFile)
-	f, err := os.Create(resultsFile)
+	return strconv.FormatBool(*b)
+}
+
+// headroomRatio returns how many times larger the upper bound is than the target, formatted to 2 decimal places.
+// A large ratio indicates a spiky workload which may need more headroom than the target suggests.
+func headroomRatio(upper, target int64) string {
+	if target == 0 {
+		return ""
+	}
+
+	return strconv.FormatFloat(float64(upper)/float64(target), 'f', 2, 64)
+}
+
+// stabilityScore returns the spread between the bounds relative to the target, (upper-lower)/target, formatted to 2 decimal
+// places. Low scores are stable workloads which can be sized tightly, high scores volatile ones needing headroom. Empty if
+// any of the values are missing.
+func stabilityScore(lower, upper, target int64) string {
+	if lower == 0 || upper == 0 || target == 0 {
+		return ""
+	}
+
+	return strconv.FormatFloat(float64(upper-lower)/float64(target), 'f', 2, 64)
+}
+
+// readNamespacesFile appends the namespaces listed one per line in path to namespaces, skipping duplicates.
+// Blank lines and lines starting with # are ignored.
+func readNamespacesFile(path string, namespaces []string) ([]string, error) {
+	data, err := os.ReadFile(path)
 	if err != nil {
-		return fmt.Errorf("creating results file: %w", err)
+		return nil, fmt.Errorf("error reading namespaces file: %w", err)
 	}
 
-	w := csv.NewWriter(f)
-	for _, record := range csvSource {
-		if err := w.Write(record); err != nil {
-			return fmt.Errorf("writing results to csv: %w", err)
+	for _, line := range strings.Split(string(data), "\n") {
+		namespace := strings.TrimSpace(line)
+		if namespace == "" || strings.HasPrefix(namespace, "#") || slices.Contains(namespaces, namespace) {
+			continue
 		}
+		namespaces = append(namespaces, namespace)
 	}
-	w.Flush()
-	if err := w.Error(); err != nil {
-		return fmt.Errorf("flushing csv writer: %w", err)
+
+	return namespaces, nil
+}
+
+// validateNamespaces returns the namespaces which exist in the cluster, warning about any which don't, e.g. due to a typo.
+// Terminating namespaces are treated as missing unless includeTerminating is set. With strict, a missing namespace is an
+// error instead.
+func validateNamespaces(ctx context.Context, client kubernetes.Interface, namespaces []string, strict, includeTerminating bool, l *slog.Logger) ([]string, error) {
+	existing, err := collector.GetNamespaces(ctx, client, true, includeTerminating)
+	if err != nil {
+		return nil, err
 	}
 
-	return nil
+	valid := make([]string, 0, len(namespaces))
+	for _, namespace := range namespaces {
+		if slices.Contains(existing, namespace) {
+			valid = append(valid, namespace)
+			continue
+		}
+		if strict {
+			return nil, fmt.Errorf("namespace %s does not exist or is terminating", namespace)
+		}
+		l.Warn("Namespace does not exist or is terminating. Skipping", "namespace", namespace)
+	}
+
+	return valid, nil
 }
 
-// getNamespaces returns all the namespaces in the cluster
-func getNamespaces(client *kubernetes.Clientset) ([]string, error) {
-	result := make([]string, 0)
+// kubeconfigDataEnv can hold the raw kubeconfig, e.g. when it's injected from a secret in CI
+const kubeconfigDataEnv = "KUBECONFIG_DATA"
 
-	namespaces, err := client.CoreV1().Namespaces().List(context.TODO(), metav1.ListOptions{})
-	if err != nil {
-		return result, fmt.Errorf("error listing namespaces: %v", err)
+// buildConfig returns the client config from the raw kubeconfig data if provided, so that it never has to be written to disk.
+// Otherwise the default kubeconfig location is used.
+func buildConfig(kubeconfigData string) (*rest.Config, error) {
+	// Read here rather than used as the flag default, so that the contents aren't printed in the -help output
+	if kubeconfigData == "" {
+		kubeconfigData = os.Getenv(kubeconfigDataEnv)
+	}
+
+	if kubeconfigData != "" {
+		config, err := clientcmd.RESTConfigFromKubeConfig([]byte(kubeconfigData))
+		if err != nil {
+			return nil, fmt.Errorf("error loading kubeconfig data: %w", err)
+		}
+		return config, nil
 	}
 
-	for _, ns := range namespaces.Items {
-		result = append(result, ns.Name)
+	return clientcmd.BuildConfigFromFlags("", filepath.Join(homedir.HomeDir(), ".kube", "config"))
+}
+
+// applyTLSOptions overrides the API server TLS verification settings from the kubeconfig, for clusters with self-signed certs.
+func applyTLSOptions(config *rest.Config, insecure bool, caFile string, l *slog.Logger) error {
+	if insecure && caFile != "" {
+		return fmt.Errorf("-insecure-skip-tls-verify and -ca-file are mutually exclusive")
 	}
 
-	return result, nil
+	if caFile != "" {
+		if _, err := os.Stat(caFile); err != nil {
+			return fmt.Errorf("error reading -ca-file: %w", err)
+		}
+		// The CA data embedded in the kubeconfig takes precedence over the file, so it has to be cleared
+		config.CAFile, config.CAData = caFile, nil
+	}
+
+	if insecure {
+		l.Warn("!!! TLS VERIFICATION OF THE API SERVER IS DISABLED. The connection is vulnerable to interception. Only use against lab clusters !!!")
+		// client-go refuses to combine insecure with a root CA
+		config.Insecure, config.CAFile, config.CAData = true, "", nil
+	}
+
+	return nil
 }
 
 // getLogger creates a structured logger and defaults to error level (https://pkg.go.dev/log/slog#Level).
-func getLogger() (*slog.Logger, error) {
+// If quiet is set the level is raised to at least warn, so that only problems are reported.
+func getLogger(quiet bool) (*slog.Logger, error) {
 	var logger *slog.Logger
 
 	var logLevel = os.Getenv("LOG_LEVEL")
@@ -336,6 +707,9 @@ func getLogger() (*slog.Logger, error) {
 	if err != nil {
 		return logger, fmt.Errorf("error parsing LOG_LEVEL: %v", err)
 	}
+	if quiet && slog.Level(level) < slog.LevelWarn {
+		level = int(slog.LevelWarn)
+	}
 	handler := slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.Level(level)})
 	logger = slog.New(handler)
 
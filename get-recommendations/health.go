@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// healthServer serves liveness and readiness probes when running as a long-lived process (e.g. -watch).
+type healthServer struct {
+	server *http.Server
+	ready  atomic.Bool
+}
+
+func newHealthServer(addr string) *healthServer {
+	h := &healthServer{}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("ok"))
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, _ *http.Request) {
+		// Only ready once the first collection cycle has completed successfully
+		if !h.ready.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = w.Write([]byte("not ready"))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("ok"))
+	})
+
+	h.server = &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+
+	return h
+}
+
+// start serves the probes in the background until ctx is cancelled.
+func (h *healthServer) start(ctx context.Context, l *slog.Logger) {
+	go func() {
+		l.Info("Serving health endpoints", "addr", h.server.Addr)
+		if err := h.server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			l.Error("Health server failed", "error", err)
+		}
+	}()
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = h.server.Shutdown(shutdownCtx)
+	}()
+}
+
+// markReady flips the readiness probe to healthy.
+func (h *healthServer) markReady() {
+	h.ready.Store(true)
+}
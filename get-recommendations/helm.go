@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"get-recommendations/collector"
+)
+
+// containerPlaceholder in the -helm-values-path is replaced with the container name, for charts with values per container
+const containerPlaceholder = "{container}"
+
+// writeHelmValues writes a YAML document per result, holding the recommendations nested under the dotted values path in the same
+// structure as a chart's resources value, e.g. resources.requests.cpu. Each document can be pasted into the workload's values.yaml.
+func writeHelmValues(w io.Writer, results []collector.ContainerConfig, valuesPath string) error {
+	var b strings.Builder
+	for _, r := range results {
+		requests := make([]string, 0, 2)
+		if r.TargetCPUStr != collector.Pending {
+			requests = append(requests, fmt.Sprintf("cpu: %s", r.TargetCPUStr))
+		}
+		if r.TargetMemoryStr != collector.Pending {
+			requests = append(requests, fmt.Sprintf("memory: %s", r.TargetMemoryStr))
+		}
+		if len(requests) == 0 {
+			continue
+		}
+
+		fmt.Fprintf(&b, "---\n# %s/%s/%s, container %s\n", r.Namespace, r.ResourceType, r.ResourceName, r.ContainerName)
+		keys := strings.Split(strings.ReplaceAll(valuesPath, containerPlaceholder, r.ContainerName), ".")
+		keys = append(keys, "requests")
+		for depth, key := range keys {
+			fmt.Fprintf(&b, "%s%s:\n", strings.Repeat("  ", depth), key)
+		}
+		for _, request := range requests {
+			fmt.Fprintf(&b, "%s%s\n", strings.Repeat("  ", len(keys)), request)
+		}
+	}
+
+	if _, err := io.WriteString(w, b.String()); err != nil {
+		return fmt.Errorf("writing helm values: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"get-recommendations/collector"
+)
+
+// kubectlKinds are the kinds whose pod template kubectl set resources can update
+var kubectlKinds = []string{"Deployment", "StatefulSet", "DaemonSet", "ReplicaSet"}
+
+// writeKubectlCommands writes a kubectl set resources command per result, setting the container's requests to the
+// recommendations, so that a reviewed subset can be run by hand. Results for kinds kubectl can't update, or summed across
+// containers, are written as comments.
+func writeKubectlCommands(w io.Writer, results []collector.ContainerConfig) error {
+	var b strings.Builder
+	b.WriteString("#!/bin/sh\n# Review before running. Each command sets the requests of one container to the VPA recommendation\n")
+	for _, r := range results {
+		requests := make([]string, 0, 2)
+		if r.TargetCPUStr != collector.Pending {
+			requests = append(requests, fmt.Sprintf("cpu=%dm", r.TargetCPU))
+		}
+		if r.TargetMemoryStr != collector.Pending {
+			requests = append(requests, fmt.Sprintf("memory=%s", collector.FormatMemory(r.TargetMemory)))
+		}
+		if len(requests) == 0 {
+			continue
+		}
+
+		// kubectl matches -c exactly, so the template's spelling is used where the VPA's container name was matched to one
+		container := r.ContainerName
+		if r.CurrentConfig.ContainerName != "" {
+			container = r.CurrentConfig.ContainerName
+		}
+
+		command := fmt.Sprintf("kubectl set resources %s/%s -n %s -c %s --requests=%s", strings.ToLower(r.ResourceType), r.ResourceName, r.Namespace, container, strings.Join(requests, ","))
+		if !slices.Contains(kubectlKinds, r.ResourceType) || r.ContainerName == allContainers {
+			command = "# Not supported: " + command
+		}
+		b.WriteString(command + "\n")
+	}
+
+	if _, err := io.WriteString(w, b.String()); err != nil {
+		return fmt.Errorf("writing kubectl commands: %w", err)
+	}
+
+	return nil
+}
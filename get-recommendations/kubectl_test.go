@@ -0,0 +1,33 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"get-recommendations/collector"
+)
+
+func TestWriteKubectlCommandsUsesTemplateContainerName(t *testing.T) {
+	results := []collector.ContainerConfig{
+		{
+			Namespace:       "team",
+			ResourceType:    "Deployment",
+			ResourceName:    "api",
+			ContainerName:   "app",
+			TargetCPUStr:    "250m",
+			TargetCPU:       250,
+			TargetMemoryStr: collector.Pending,
+			CurrentConfig:   collector.ResourceDrift{ContainerType: collector.ContainerRegular, ContainerName: "App"},
+		},
+	}
+
+	var b strings.Builder
+	if err := writeKubectlCommands(&b, results); err != nil {
+		t.Fatalf("writeKubectlCommands() error = %v", err)
+	}
+
+	want := "kubectl set resources deployment/api -n team -c App --requests=cpu=250m\n"
+	if !strings.HasSuffix(b.String(), want) {
+		t.Errorf("writeKubectlCommands() = %q, want it to end with %q", b.String(), want)
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"time"
+
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/util/homedir"
+)
+
+// metaFile records how the results were produced, for reproducibility
+const metaFile = "results.meta.json"
+
+// Flags whose values are secret and so not recorded in the metadata
+var redactedFlags = []string{"kubeconfig-data"}
+
+type runMetadata struct {
+	RunAt         time.Time         `json:"runAt"`
+	Context       string            `json:"context,omitempty"`
+	Server        string            `json:"server"`
+	Version       string            `json:"version"`
+	SchemaVersion int               `json:"schemaVersion"`
+	Flags         map[string]string `json:"flags"`
+}
+
+// newRunMetadata returns the metadata for the cluster and the parsed flags. The run timestamp is set when it's written.
+func newRunMetadata(server, kubeconfigData string) runMetadata {
+	m := runMetadata{
+		Context:       currentContext(kubeconfigData),
+		Server:        server,
+		Version:       toolVersion(),
+		SchemaVersion: schemaVersion,
+		Flags:         make(map[string]string),
+	}
+
+	flag.VisitAll(func(f *flag.Flag) {
+		value := f.Value.String()
+		for _, name := range redactedFlags {
+			if f.Name == name && value != "" {
+				value = "REDACTED"
+			}
+		}
+		m.Flags[f.Name] = value
+	})
+
+	return m
+}
+
+// currentContext returns the name of the kubeconfig context in use, or an empty string if the kubeconfig can't be read.
+func currentContext(kubeconfigData string) string {
+	if kubeconfigData == "" {
+		kubeconfigData = os.Getenv(kubeconfigDataEnv)
+	}
+
+	if kubeconfigData != "" {
+		c, err := clientcmd.Load([]byte(kubeconfigData))
+		if err != nil {
+			return ""
+		}
+		return c.CurrentContext
+	}
+
+	c, err := clientcmd.LoadFromFile(filepath.Join(homedir.HomeDir(), ".kube", "config"))
+	if err != nil {
+		return ""
+	}
+
+	return c.CurrentContext
+}
+
+// toolVersion returns the module version and VCS revision the binary was built from, where known.
+func toolVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+
+	version := info.Main.Version
+	for _, s := range info.Settings {
+		if s.Key == "vcs.revision" {
+			version = fmt.Sprintf("%s (%s)", version, s.Value)
+		}
+	}
+
+	return version
+}
+
+// writeRunMetadata writes the metadata, stamped with the run time, to metaFile.
+func writeRunMetadata(m runMetadata, runAt time.Time) error {
+	m.RunAt = runAt.UTC()
+
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding run metadata: %w", err)
+	}
+
+	if err := os.WriteFile(metaFile, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("writing run metadata file: %w", err)
+	}
+
+	return nil
+}
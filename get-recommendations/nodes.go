@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"get-recommendations/collector"
+)
+
+// maxNodeAllocatableMemory returns the largest allocatable memory (bytes) of any node in the cluster.
+func maxNodeAllocatableMemory(ctx context.Context, clientset *kubernetes.Clientset) (int64, error) {
+	nodes, err := clientset.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return 0, &collector.APIError{Op: "listing nodes", Err: err}
+	}
+
+	var largest int64
+	for _, node := range nodes.Items {
+		if mem := node.Status.Allocatable.Memory().Value(); mem > largest {
+			largest = mem
+		}
+	}
+
+	return largest, nil
+}
+
+// flagUnschedulableMemory marks results whose memory recommendation is larger than any node can allocate, as those pods
+// could never be scheduled. If the nodes can't be listed (e.g. missing RBAC permissions) the check is skipped and the error
+// returned, which callers should treat as a warning rather than fail the run.
+func flagUnschedulableMemory(ctx context.Context, clientset *kubernetes.Clientset, results []collector.ContainerConfig, l *slog.Logger) error {
+	largest, err := maxNodeAllocatableMemory(ctx, clientset)
+	if err != nil {
+		return err
+	}
+	if largest == 0 {
+		return nil
+	}
+
+	for i := range results {
+		r := &results[i]
+		exceeds := r.TargetMemory > largest
+		r.ExceedsNodeMemory = &exceeds
+
+		if exceeds {
+			l.Warn("Memory recommendation exceeds the allocatable memory of the largest node and can never be scheduled", "namespace", r.Namespace, "resourceType", r.ResourceType, "resourceName", r.ResourceName, "container", r.ContainerName, "recommendedMemory", r.TargetMemoryStr, "largestNodeAllocatable", resource.NewQuantity(largest, resource.BinarySI).String())
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,379 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"get-recommendations/collector"
+)
+
+// Values for the -format flag
+const (
+	formatCSV      = "csv"
+	formatMarkdown = "markdown"
+	formatHelm     = "helm"
+	formatKubectl  = "kubectl"
+)
+
+var outputFormats = []string{formatCSV, formatMarkdown, formatHelm, formatKubectl}
+
+// formatFlags lists the flags which only affect some output formats, along with those formats. The helm and kubectl formats
+// only output the recommendations, so ignore the column flags.
+var formatFlags = map[string][]string{
+	"no-header":           {formatCSV},
+	"csv-bom":             {formatCSV},
+	"markdown-rows":       {formatMarkdown},
+	"helm-values-path":    {formatHelm},
+	"output-fields":       {formatCSV, formatMarkdown},
+	"extra-label-columns": {formatCSV, formatMarkdown},
+	"capped-diffs":        {formatCSV, formatMarkdown},
+	"diff-format":         {formatCSV, formatMarkdown},
+	"human":               {formatCSV, formatMarkdown},
+	"totals":              {formatCSV, formatMarkdown},
+}
+
+// validateFormatFlags returns an error for the first of the set flags which has no effect with the output format, so that
+// it's rejected up front rather than silently ignored.
+func validateFormatFlags(format string, set []string) error {
+	for _, name := range set {
+		formats, found := formatFlags[name]
+		if found && !slices.Contains(formats, format) {
+			return fmt.Errorf("-%s can't be used with -format=%s, only with -format=%s", name, format, strings.Join(formats, " or -format="))
+		}
+	}
+
+	return nil
+}
+
+// utf8BOM is written at the start of CSV files with -csv-bom
+const utf8BOM = "\ufeff"
+
+// skipBOM returns a reader over r without its leading UTF-8 byte order mark, if any. Used when reading back results written
+// with -csv-bom, as csv.Reader would otherwise read the mark as part of the schema version comment and not skip it.
+func skipBOM(r io.Reader) io.Reader {
+	br := bufio.NewReader(r)
+	if b, err := br.Peek(len(utf8BOM)); err == nil && string(b) == utf8BOM {
+		br.Discard(len(utf8BOM))
+	}
+
+	return br
+}
+
+// writeResults writes the results in the selected output format, gzip compressing them if enabled.
+func writeResults(results []collector.ContainerConfig, opts options) error {
+	return writeResultsFile(resultsPath(opts), results, opts)
+}
+
+// writeDirectionResults writes the results which recommend increasing any resource to the increase file, and those which recommend
+// decreasing any resource to the decrease file. Containers recommended to increase one resource and decrease another are in both.
+func writeDirectionResults(results []collector.ContainerConfig, opts options) error {
+	increase := make([]collector.ContainerConfig, 0)
+	decrease := make([]collector.ContainerConfig, 0)
+	for _, r := range results {
+		if r.CurrentConfig.CPUDiff > 0 || r.CurrentConfig.MemDiff > 0 {
+			increase = append(increase, r)
+		}
+		if r.CurrentConfig.CPUDiff < 0 || r.CurrentConfig.MemDiff < 0 {
+			decrease = append(decrease, r)
+		}
+	}
+
+	if err := writeResultsFile(namedResultsPath(increaseResultsFile, opts), increase, opts); err != nil {
+		return err
+	}
+
+	return writeResultsFile(namedResultsPath(decreaseResultsFile, opts), decrease, opts)
+}
+
+// writeNamespaceResults writes a results file per namespace into dir, e.g. results-<namespace>.csv, so that each team can be
+// handed just their rows. The directory is created if it doesn't exist.
+func writeNamespaceResults(results []collector.ContainerConfig, dir string, opts options) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("creating per-namespace output directory: %w", err)
+	}
+
+	namespaces := make([]string, 0)
+	byNamespace := make(map[string][]collector.ContainerConfig)
+	for _, r := range results {
+		if _, found := byNamespace[r.Namespace]; !found {
+			namespaces = append(namespaces, r.Namespace)
+		}
+		byNamespace[r.Namespace] = append(byNamespace[r.Namespace], r)
+	}
+
+	for _, namespace := range namespaces {
+		path := filepath.Join(dir, namedResultsPath(fmt.Sprintf("%s-%s", resultsFile, namespace), opts))
+		if err := writeResultsFile(path, byNamespace[namespace], opts); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeResultsFile writes the results to path in the selected output format, gzip compressing them if enabled.
+// The results are written to a temp file which is renamed over path once complete, so consumers reading path never see a
+// partially written file, and the previous results are left intact if writing fails.
+func writeResultsFile(path string, results []collector.ContainerConfig, opts options) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("creating results file: %w", err)
+	}
+	renamed := false
+	defer func() {
+		if !renamed {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+
+	// CreateTemp restricts the file to the owner, whereas the results are read by other processes
+	if err := f.Chmod(0644); err != nil {
+		return fmt.Errorf("setting results file permissions: %w", err)
+	}
+
+	var w io.Writer = f
+	var gz *gzip.Writer
+	if opts.gzip {
+		gz = gzip.NewWriter(f)
+		w = gz
+	}
+
+	switch opts.format {
+	case formatMarkdown:
+		err = writeMarkdown(w, results, opts.columns, opts.markdownRows)
+	case formatHelm:
+		err = writeHelmValues(w, results, opts.helmValuesPath)
+	case formatKubectl:
+		err = writeKubectlCommands(w, results)
+	default:
+		// Excel otherwise guesses the encoding from the system locale rather than reading the file as UTF-8
+		if opts.csvBOM {
+			if _, err := io.WriteString(w, utf8BOM); err != nil {
+				return fmt.Errorf("writing byte order mark: %w", err)
+			}
+		}
+		err = writeCSV(w, results, opts.columns, !opts.noHeader)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Closing the gzip writer flushes any buffered data and writes the gzip footer
+	if gz != nil {
+		if err := gz.Close(); err != nil {
+			return fmt.Errorf("closing gzip writer: %w", err)
+		}
+	}
+
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("closing results file: %w", err)
+	}
+
+	// Atomic on POSIX filesystems, as the temp file is in the same directory
+	if err := os.Rename(f.Name(), path); err != nil {
+		return fmt.Errorf("renaming results file into place: %w", err)
+	}
+	renamed = true
+
+	return nil
+}
+
+// resultRows returns the header followed by a row per result, shared by each of the output formats.
+func resultRows(results []collector.ContainerConfig, cols []column) [][]string {
+	rows := make([][]string, 0, len(results)+1)
+
+	header := make([]string, 0, len(cols))
+	for _, c := range cols {
+		header = append(header, c.header)
+	}
+	rows = append(rows, header)
+
+	for _, r := range results {
+		row := make([]string, 0, len(cols))
+		for _, c := range cols {
+			row = append(row, c.value(r))
+		}
+		rows = append(rows, row)
+	}
+
+	return rows
+}
+
+// writeCSV writes the schema version comment, header and a row per result to w.
+func writeCSV(w io.Writer, results []collector.ContainerConfig, cols []column, header bool) error {
+	// csv package expects a slice of string slices. Each slice is a CSV row
+	csvSource := resultRows(results, cols)
+
+	if header {
+		// Parsers can skip this line by treating '#' as a comment character (csv.Reader.Comment in Go)
+		if _, err := fmt.Fprintf(w, "# schemaVersion: %d\n", schemaVersion); err != nil {
+			return fmt.Errorf("writing schema version: %w", err)
+		}
+	} else {
+		// Left out along with the schema version, e.g. when appending to an existing dataset
+		csvSource = csvSource[1:]
+	}
+
+	cw := csv.NewWriter(w)
+	for _, record := range csvSource {
+		if err := cw.Write(record); err != nil {
+			return fmt.Errorf("writing results to csv: %w", err)
+		}
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return fmt.Errorf("flushing csv writer: %w", err)
+	}
+
+	return nil
+}
+
+// writePartialResults writes the results collected before the run was interrupted to a separate file, leaving the results of
+// the last complete run intact. Nothing is done to them beyond collection, e.g. they aren't grouped or limited.
+func writePartialResults(results []collector.ContainerConfig, opts options, l *slog.Logger) error {
+	path := namedResultsPath(resultsFile+".partial", opts)
+	l.Warn("Interrupted. Writing the results collected so far", "count", len(results), "file", path)
+	return writeResultsFile(path, results, opts)
+}
+
+// resultsPath returns the name of the results file for the output format, which has a .gz suffix when compressed.
+func resultsPath(opts options) string {
+	return namedResultsPath(resultsFile, opts)
+}
+
+// namedResultsPath returns the path of a results file with the base name, with the extension of the selected format.
+func namedResultsPath(name string, opts options) string {
+	path := name + ".csv"
+	switch opts.format {
+	case formatMarkdown:
+		path = name + ".md"
+	case formatHelm:
+		path = name + ".yaml"
+	case formatKubectl:
+		path = name + ".sh"
+	}
+
+	if opts.gzip {
+		return path + ".gz"
+	}
+
+	return path
+}
+
+// writeMarkdown writes the results as a GitHub-flavored Markdown table, for pasting into PRs and tickets.
+// If limit is greater than zero, only the rows with the largest drift are included.
+func writeMarkdown(w io.Writer, results []collector.ContainerConfig, cols []column, limit int) error {
+	if limit > 0 && limit < len(results) {
+		results = slices.Clone(results)
+		sortByDrift(results)
+		results = results[:limit]
+	}
+
+	var b strings.Builder
+	for i, row := range resultRows(results, cols) {
+		cells := make([]string, len(row))
+		for j, cell := range row {
+			cells[j] = markdownEscaper.Replace(cell)
+		}
+		b.WriteString("| " + strings.Join(cells, " | ") + " |\n")
+
+		// The delimiter row separates the header from the body
+		if i == 0 {
+			b.WriteString(strings.Repeat("| --- ", len(row)) + "|\n")
+		}
+	}
+
+	if _, err := io.WriteString(w, b.String()); err != nil {
+		return fmt.Errorf("writing markdown table: %w", err)
+	}
+
+	return nil
+}
+
+// markdownEscaper escapes characters which would otherwise break the table layout
+var markdownEscaper = strings.NewReplacer("|", "\\|", "\n", " ")
+
+// sortByDrift orders the results by their largest relative drift from the current config, descending.
+func sortByDrift(results []collector.ContainerConfig) {
+	sort.SliceStable(results, func(i, j int) bool {
+		return driftScore(results[i]) > driftScore(results[j])
+	})
+}
+
+// Values for the -sort-by flag, used to pick the rows kept by -max-results
+const (
+	sortDrift   = "drift"       // largest relative drift, see driftScore
+	sortCPUDiff = "cpu-diff"    // largest absolute CPU diff
+	sortMemDiff = "memory-diff" // largest absolute memory diff
+)
+
+var sortCriteria = []string{sortDrift, sortCPUDiff, sortMemDiff}
+
+// topResults orders the results by the sort criterion, descending, and keeps the first n. Returns the kept results and
+// the number dropped.
+func topResults(results []collector.ContainerConfig, n int, sortBy string) ([]collector.ContainerConfig, int) {
+	score := driftScore
+	switch sortBy {
+	case sortCPUDiff:
+		score = func(r collector.ContainerConfig) float64 { return math.Abs(float64(r.CurrentConfig.CPUDiff)) }
+	case sortMemDiff:
+		score = func(r collector.ContainerConfig) float64 { return math.Abs(float64(r.CurrentConfig.MemDiff)) }
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		return score(results[i]) > score(results[j])
+	})
+
+	if len(results) <= n {
+		return results, 0
+	}
+
+	return results[:n], len(results) - n
+}
+
+// driftScore is the larger of the absolute CPU and memory diffs, relative to the current requests.
+// Containers without current requests can't be compared and score zero.
+func driftScore(r collector.ContainerConfig) float64 {
+	cpu, _ := relativeDrift(r.CurrentConfig.CPUDiff, r.CurrentConfig.CurrentCPU)
+	mem, _ := relativeDrift(r.CurrentConfig.MemDiff, r.CurrentConfig.CurrentMem)
+
+	return math.Max(cpu, mem)
+}
+
+// relativeDrift returns the absolute diff as a fraction of the current value, and false if there is no current value to compare against.
+func relativeDrift(diff, current int64) (float64, bool) {
+	if current <= 0 {
+		return 0, false
+	}
+
+	return math.Abs(float64(diff) / float64(current)), true
+}
+
+// primaryDriver reports whether CPU or memory has the larger relative drift, as a triage signal for which resource to look at first.
+// Empty if neither current request is set.
+func primaryDriver(r collector.ContainerConfig) string {
+	cpu, cpuOK := relativeDrift(r.CurrentConfig.CPUDiff, r.CurrentConfig.CurrentCPU)
+	mem, memOK := relativeDrift(r.CurrentConfig.MemDiff, r.CurrentConfig.CurrentMem)
+
+	switch {
+	case !cpuOK && !memOK:
+		return ""
+	case cpu > mem:
+		return "cpu"
+	case mem > cpu:
+		return "memory"
+	default:
+		return "balanced"
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"get-recommendations/collector"
+)
+
+func TestWriteResultsCSVRoundTrip(t *testing.T) {
+	r := collector.ContainerConfig{
+		Namespace:       "default",
+		ResourceType:    "Deployment",
+		ResourceName:    `api, "v2"`,
+		ContainerName:   "sidecar,proxy",
+		VPAName:         "api-vpa",
+		TargetCPUStr:    "250m",
+		TargetMemoryStr: "512Mi",
+		Team:            "platform\n\"core\"",
+		CurrentConfig:   collector.ResourceDrift{CurrentCPUStr: collector.NotSet, CurrentMemStr: collector.NotSet},
+	}
+
+	path := filepath.Join(t.TempDir(), "results.csv")
+	err := writeResultsFile(path, []collector.ContainerConfig{r}, options{format: formatCSV, columns: columns})
+	if err != nil {
+		t.Fatalf("writeResultsFile() error = %v", err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	reader := csv.NewReader(f)
+	reader.Comment = '#'
+	records, err := reader.ReadAll()
+	if err != nil {
+		t.Fatalf("reading results back: %v", err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("got %d records, want a header and one row", len(records))
+	}
+
+	want := resultRows([]collector.ContainerConfig{r}, columns)
+	if !slices.Equal(records[0], want[0]) {
+		t.Errorf("header = %q, want %q", records[0], want[0])
+	}
+	if !slices.Equal(records[1], want[1]) {
+		t.Errorf("row = %q, want %q", records[1], want[1])
+	}
+
+	for _, check := range []struct{ header, want string }{
+		{"resourceName", r.ResourceName},
+		{"containerName", r.ContainerName},
+		{"team", r.Team},
+	} {
+		i := slices.Index(records[0], check.header)
+		if i < 0 {
+			t.Fatalf("column %q missing from header", check.header)
+		}
+		if got := records[1][i]; got != check.want {
+			t.Errorf("%s = %q, want %q", check.header, got, check.want)
+		}
+	}
+}
+
+func TestWriteResultsCSVBOMReadAsPrevious(t *testing.T) {
+	r := collector.ContainerConfig{
+		Namespace:       "default",
+		ResourceType:    "Deployment",
+		ResourceName:    "api",
+		ContainerName:   "app",
+		TargetCPUStr:    "250m",
+		TargetMemoryStr: "512Mi",
+		CurrentConfig:   collector.ResourceDrift{CurrentCPUStr: collector.NotSet, CurrentMemStr: collector.NotSet},
+	}
+
+	path := filepath.Join(t.TempDir(), "results.csv")
+	err := writeResultsFile(path, []collector.ContainerConfig{r}, options{format: formatCSV, columns: columns, csvBOM: true})
+	if err != nil {
+		t.Fatalf("writeResultsFile() error = %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(data), utf8BOM+"#") {
+		t.Fatalf("results don't start with the byte order mark and schema version comment: %q", data[:min(len(data), 20)])
+	}
+
+	previous, err := loadPreviousResults(path)
+	if err != nil {
+		t.Fatalf("loadPreviousResults() error = %v", err)
+	}
+	got, found := previous[recommendationKey("default", "Deployment", "api", "app")]
+	if !found {
+		t.Fatalf("loadPreviousResults() = %v, missing the container", previous)
+	}
+	if got.cpu != 250 || got.mem != 512*1024*1024 {
+		t.Errorf("loadPreviousResults() = %+v, want cpu 250, mem %d", got, 512*1024*1024)
+	}
+}
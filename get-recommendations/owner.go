@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"get-recommendations/collector"
+)
+
+// groupByOwner is the -group-by mode which rolls the results up to each workload's top-level controller
+const groupByOwner = "owner"
+
+// groupResultsByOwner collapses the results for each container under the workloads' top-level controllers, e.g. the
+// ReplicaSets of a Deployment. The recommendations and current requests remain per pod, averaged across the children weighted
+// by their replicas, so that multiplying by the summed replicas of the children gives the owner's totals. Children all scaled
+// to zero are weighted equally. The VPAs of the children are joined in the vpaName column.
+func groupResultsByOwner(results []collector.ContainerConfig) []collector.ContainerConfig {
+	ownerKey := func(r collector.ContainerConfig) string {
+		return fmt.Sprintf("%s/%s/%s", r.Namespace, r.OwnerKind, r.OwnerName)
+	}
+	groupKey := func(r collector.ContainerConfig) string {
+		return fmt.Sprintf("%s/%s", ownerKey(r), r.ContainerName)
+	}
+
+	vpas := make(map[string][]string)
+	replicas := make(map[string]map[string]int32)
+	rows := make([]collector.ContainerConfig, 0, len(results))
+	for _, r := range results {
+		if r.OwnerKind == "" {
+			r.OwnerKind, r.OwnerName = r.ResourceType, r.ResourceName
+		}
+		k := ownerKey(r)
+
+		if !slices.Contains(vpas[k], r.VPAName) {
+			vpas[k] = append(vpas[k], r.VPAName)
+		}
+		if replicas[k] == nil {
+			replicas[k] = make(map[string]int32)
+		}
+		replicas[k][r.ResourceType+"/"+r.ResourceName] = r.CurrentConfig.Replicas
+
+		r.ResourceType, r.ResourceName = r.OwnerKind, r.OwnerName
+		rows = append(rows, r)
+	}
+
+	totals := make(map[string]int32, len(replicas))
+	for k, children := range replicas {
+		for _, n := range children {
+			totals[k] += n
+		}
+	}
+
+	// Each row is weighted by its replicas before summing, then divided by the summed weights
+	groupReplicas := make(map[string]int64)
+	for _, r := range rows {
+		groupReplicas[groupKey(r)] += int64(r.CurrentConfig.Replicas)
+	}
+	weights := make(map[string]int64)
+	for i := range rows {
+		weight := int64(rows[i].CurrentConfig.Replicas)
+		if groupReplicas[groupKey(rows[i])] == 0 {
+			weight = 1
+		}
+		weights[groupKey(rows[i])] += weight
+		scaleResult(&rows[i], weight, 1)
+	}
+
+	grouped := sumBy(rows, groupKey)
+	for i := range grouped {
+		scaleResult(&grouped[i], 1, weights[groupKey(grouped[i])])
+		formatSums(&grouped[i])
+
+		k := ownerKey(grouped[i])
+		grouped[i].VPAName = strings.Join(vpas[k], ";")
+		grouped[i].CurrentConfig.Replicas = totals[k]
+	}
+
+	return grouped
+}
@@ -0,0 +1,54 @@
+package main
+
+import "get-recommendations/collector"
+
+import "testing"
+
+func TestGroupResultsByOwnerTotals(t *testing.T) {
+	child := func(name string, replicas int32, target, current int64) collector.ContainerConfig {
+		return collector.ContainerConfig{
+			Namespace:       "default",
+			ResourceType:    "ReplicaSet",
+			ResourceName:    name,
+			ContainerName:   "app",
+			VPAName:         name,
+			TargetCPUStr:    "set",
+			TargetMemoryStr: collector.Pending,
+			TargetCPU:       target,
+			OwnerKind:       "Deployment",
+			OwnerName:       "api",
+			CurrentConfig: collector.ResourceDrift{
+				CurrentCPUStr: "set",
+				CurrentMemStr: collector.NotSet,
+				CurrentCPU:    current,
+				CPUDiff:       target - current,
+				Replicas:      replicas,
+			},
+		}
+	}
+
+	// The old ReplicaSet is recommended less than its 200m and the new one more
+	grouped := groupResultsByOwner([]collector.ContainerConfig{
+		child("api-old", 3, 100, 200),
+		child("api-new", 1, 300, 200),
+	})
+	if len(grouped) != 1 {
+		t.Fatalf("groupResultsByOwner() returned %d rows, want 1", len(grouped))
+	}
+	g := grouped[0]
+
+	if g.CurrentConfig.Replicas != 4 {
+		t.Errorf("replicas = %d, want 4", g.CurrentConfig.Replicas)
+	}
+	if g.TargetCPU != 150 || g.TargetCPUStr != "150m" {
+		t.Errorf("per pod target = %d (%s), want 150 (150m)", g.TargetCPU, g.TargetCPUStr)
+	}
+
+	// -totals gives the cluster-wide change: -100m on 3 pods and +100m on 1
+	if got := diffOf("cpuDiff", true)(g); got != -200 {
+		t.Errorf("total cpu diff = %d, want -200", got)
+	}
+	if got := recommendedTotals(grouped)["default"].cpu; got != 600 {
+		t.Errorf("recommended namespace total = %d, want 600", got)
+	}
+}
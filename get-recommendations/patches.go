@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"get-recommendations/collector"
+)
+
+// patchesFile holds a strategic merge patch per workload, setting its containers' resources to the recommendations
+const patchesFile = "patches.json"
+
+// Which resources the generated patches set
+const (
+	patchRequests          = "requests"
+	patchRequestsAndLimits = "requests-and-limits"
+)
+
+type containerResources struct {
+	Requests map[string]string `json:"requests,omitempty"`
+	Limits   map[string]string `json:"limits,omitempty"`
+}
+
+type containerPatch struct {
+	Name      string             `json:"name"`
+	Resources containerResources `json:"resources"`
+}
+
+// workloadPatch can be applied with kubectl patch <kind> <name> -n <namespace> --patch '<patch>'
+type workloadPatch struct {
+	Namespace    string    `json:"namespace"`
+	ResourceType string    `json:"resourceType"`
+	ResourceName string    `json:"resourceName"`
+	Patch        specPatch `json:"patch"`
+}
+
+// specPatch is a strategic merge patch of the pod template's containers. Containers are merged by name.
+type specPatch struct {
+	Spec struct {
+		Template struct {
+			Spec struct {
+				Containers []containerPatch `json:"containers"`
+			} `json:"spec"`
+		} `json:"template"`
+	} `json:"spec"`
+}
+
+// buildPatches returns a patch per workload setting the requests of its regular containers to the recommended targets.
+// With patchRequestsAndLimits, any limits are scaled by the same factor as the requests, preserving the existing request:limit ratio.
+func buildPatches(results []collector.ContainerConfig, mode string, l *slog.Logger) []workloadPatch {
+	patches := make([]workloadPatch, 0)
+	index := make(map[string]int)
+
+	for _, r := range results {
+		if r.CurrentConfig.ContainerType != collector.ContainerRegular {
+			l.Debug("Container not found in the workload's regular containers. Not patching", "namespace", r.Namespace, "resourceType", r.ResourceType, "resourceName", r.ResourceName, "container", r.ContainerName)
+			continue
+		}
+
+		resources := containerResources{Requests: map[string]string{}, Limits: map[string]string{}}
+		if r.TargetCPUStr != collector.Pending {
+			resources.Requests["cpu"] = fmt.Sprintf("%dm", r.TargetCPU)
+			if limit, ok := scaledLimit(r.TargetCPU, r.CurrentConfig.RequestCPU, r.CurrentConfig.LimitCPU); ok && mode == patchRequestsAndLimits {
+				resources.Limits["cpu"] = fmt.Sprintf("%dm", limit)
+			}
+		}
+		if r.TargetMemoryStr != collector.Pending {
+			resources.Requests["memory"] = collector.FormatMemory(r.TargetMemory)
+			if limit, ok := scaledLimit(r.TargetMemory, r.CurrentConfig.RequestMem, r.CurrentConfig.LimitMem); ok && mode == patchRequestsAndLimits {
+				resources.Limits["memory"] = collector.FormatMemory(limit)
+			}
+		}
+		if len(resources.Requests) == 0 {
+			continue
+		}
+
+		key := fmt.Sprintf("%s/%s/%s", r.Namespace, r.ResourceType, r.ResourceName)
+		i, found := index[key]
+		if !found {
+			i = len(patches)
+			index[key] = i
+			patches = append(patches, workloadPatch{Namespace: r.Namespace, ResourceType: r.ResourceType, ResourceName: r.ResourceName})
+		}
+
+		// Merged by the template's spelling of the name, as a VPA's container name is matched case-insensitively
+		spec := &patches[i].Patch.Spec.Template.Spec
+		spec.Containers = append(spec.Containers, containerPatch{Name: r.CurrentConfig.ContainerName, Resources: resources})
+	}
+
+	return patches
+}
+
+// scaledLimit returns the limit scaled by the same factor as the request, and false if either the current request or limit isn't set.
+func scaledLimit(target, request, limit int64) (int64, bool) {
+	if request == 0 || limit == 0 {
+		return 0, false
+	}
+
+	// Scaled as floats, as the product of two byte values can overflow an int64
+	return int64(float64(target) * float64(limit) / float64(request)), true
+}
+
+// writePatches writes the patches to patchesFile.
+func writePatches(patches []workloadPatch) error {
+	data, err := json.MarshalIndent(patches, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding patches: %w", err)
+	}
+
+	if err := os.WriteFile(patchesFile, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("writing patches file: %w", err)
+	}
+
+	return nil
+}
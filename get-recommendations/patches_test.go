@@ -0,0 +1,42 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"testing"
+
+	"get-recommendations/collector"
+)
+
+func TestBuildPatchesUsesTemplateContainerName(t *testing.T) {
+	results := []collector.ContainerConfig{
+		{
+			Namespace:       "team",
+			ResourceType:    "Deployment",
+			ResourceName:    "api",
+			ContainerName:   "app",
+			TargetCPUStr:    "250m",
+			TargetCPU:       250,
+			TargetMemoryStr: "256Mi",
+			TargetMemory:    256 * collector.Mebibyte,
+			CurrentConfig:   collector.ResourceDrift{ContainerType: collector.ContainerRegular, ContainerName: "App", RequestCPU: 100, LimitCPU: 200},
+		},
+	}
+
+	patches := buildPatches(results, patchRequestsAndLimits, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if len(patches) != 1 {
+		t.Fatalf("buildPatches() returned %d patches, want 1", len(patches))
+	}
+
+	containers := patches[0].Patch.Spec.Template.Spec.Containers
+	if len(containers) != 1 {
+		t.Fatalf("patch has %d containers, want 1", len(containers))
+	}
+	c := containers[0]
+	if c.Name != "App" {
+		t.Errorf("patched container name = %q, want the template's %q rather than the VPA's %q", c.Name, "App", "app")
+	}
+	if c.Resources.Requests["cpu"] != "250m" || c.Resources.Requests["memory"] != "256Mi" || c.Resources.Limits["cpu"] != "500m" {
+		t.Errorf("patched resources = %+v, want requests cpu=250m memory=256Mi and limits cpu=500m", c.Resources)
+	}
+}
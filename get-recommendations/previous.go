@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"strconv"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	"get-recommendations/collector"
+)
+
+// previousRecommendation is a recommendation loaded from the results file of an earlier run.
+type previousRecommendation struct {
+	cpu int64 // millicores
+	mem int64 // bytes
+}
+
+// recommendationKey uniquely identifies a container across runs.
+func recommendationKey(namespace, resourceType, resourceName, containerName string) string {
+	return fmt.Sprintf("%s/%s/%s/%s", namespace, resourceType, resourceName, containerName)
+}
+
+// loadPreviousResults reads an earlier results CSV, keyed by namespace/kind/name/container.
+// Columns are located by their header so that files written with an older schema version can still be read.
+func loadPreviousResults(path string) (map[string]previousRecommendation, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening previous results: %w", err)
+	}
+	defer f.Close()
+
+	r := csv.NewReader(skipBOM(f))
+	r.Comment = '#'
+	r.FieldsPerRecord = -1
+
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("reading previous results %s: %w", path, err)
+	}
+	if len(records) == 0 {
+		return map[string]previousRecommendation{}, nil
+	}
+
+	columns := make(map[string]int)
+	for i, name := range records[0] {
+		columns[name] = i
+	}
+	for _, required := range []string{"namespace", "resourceType", "resourceName", "containerName", "VPA Target CPU", "VPA Target Memory"} {
+		if _, found := columns[required]; !found {
+			return nil, fmt.Errorf("previous results %s is missing the %q column", path, required)
+		}
+	}
+
+	results := make(map[string]previousRecommendation, len(records)-1)
+	for _, record := range records[1:] {
+		cpu, err := resource.ParseQuantity(record[columns["VPA Target CPU"]])
+		if err != nil {
+			continue
+		}
+		mem, err := resource.ParseQuantity(record[columns["VPA Target Memory"]])
+		if err != nil {
+			continue
+		}
+
+		key := recommendationKey(record[columns["namespace"]], record[columns["resourceType"]], record[columns["resourceName"]], record[columns["containerName"]])
+		results[key] = previousRecommendation{cpu: cpu.MilliValue(), mem: mem.Value()}
+	}
+
+	return results, nil
+}
+
+// applyPreviousResults sets the change in recommendation since the previous run for each container found in both.
+func applyPreviousResults(results []collector.ContainerConfig, previous map[string]previousRecommendation) {
+	for i := range results {
+		r := &results[i]
+		p, found := previous[recommendationKey(r.Namespace, r.ResourceType, r.ResourceName, r.ContainerName)]
+		if !found {
+			continue
+		}
+		if r.TargetCPUStr != collector.Pending {
+			r.CPUTrend = strconv.FormatInt(r.TargetCPU-p.cpu, 10)
+		}
+		if r.TargetMemoryStr != collector.Pending {
+			r.MemTrend = strconv.FormatInt(r.TargetMemory-p.mem, 10)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"get-recommendations/collector"
+)
+
+// prometheusRulesFile holds a PrometheusRule with an alert per container resource, firing when the VPA target drifts from the requests
+const prometheusRulesFile = "prometheus-rules.yaml"
+
+// Metrics compared by the alerts. The VPA metric was dropped from kube-state-metrics v2.9, but is commonly re-created
+// under the same name via its custom resource state config
+const (
+	vpaTargetMetric        = "kube_verticalpodautoscaler_status_recommendation_containerrecommendations_target"
+	containerRequestMetric = "kube_pod_container_resource_requests"
+)
+
+// writePrometheusRules writes a PrometheusRule with an alert for the CPU and memory of each container which has a request set,
+// firing when the VPA target differs from the requests by more than the threshold, relative to the requests. The requests
+// are read from the workload's pods, which are matched by name prefix.
+func writePrometheusRules(results []collector.ContainerConfig, threshold float64) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, `apiVersion: monitoring.coreos.com/v1
+kind: PrometheusRule
+metadata:
+  name: vpa-recommendations
+  labels:
+    %s: %s
+spec:
+  groups:
+    - name: vpa-recommendations
+      rules:
+`, collector.ManagedByLabel, collector.ManagedByValue)
+
+	count := 0
+	for _, r := range results {
+		if r.CurrentConfig.CurrentCPU > 0 && r.TargetCPUStr != collector.Pending {
+			writeDriftAlert(&b, r, "cpu", threshold)
+			count++
+		}
+		if r.CurrentConfig.CurrentMem > 0 && r.TargetMemoryStr != collector.Pending {
+			writeDriftAlert(&b, r, "memory", threshold)
+			count++
+		}
+	}
+	if count == 0 {
+		// An empty list rather than a null rules key, which the operator rejects
+		b.WriteString("        []\n")
+	}
+
+	if err := os.WriteFile(prometheusRulesFile, []byte(b.String()), 0644); err != nil {
+		return fmt.Errorf("writing prometheus rules file: %w", err)
+	}
+
+	return nil
+}
+
+// writeDriftAlert writes the alerting rule for a single container resource.
+func writeDriftAlert(b *strings.Builder, r collector.ContainerConfig, resource string, threshold float64) {
+	target := fmt.Sprintf(`%s{namespace="%s",verticalpodautoscaler="%s",container="%s",resource="%s"}`,
+		vpaTargetMetric, r.Namespace, r.VPAName, r.ContainerName, resource)
+	requests := fmt.Sprintf(`%s{namespace="%s",pod=~"%s-.*",container="%s",resource="%s"}`,
+		containerRequestMetric, r.Namespace, r.ResourceName, r.ContainerName, resource)
+
+	fmt.Fprintf(b, "        - alert: VPARecommendationDrift\n")
+	fmt.Fprintf(b, "          expr: |\n")
+	fmt.Fprintf(b, "            abs(max(%s) - max(%s))\n", target, requests)
+	fmt.Fprintf(b, "              / max(%s) > %g\n", requests, threshold)
+	fmt.Fprintf(b, "          for: 1h\n")
+	fmt.Fprintf(b, "          labels:\n")
+	fmt.Fprintf(b, "            severity: warning\n")
+	fmt.Fprintf(b, "            namespace: %s\n", r.Namespace)
+	fmt.Fprintf(b, "            workload: %s/%s\n", r.ResourceType, r.ResourceName)
+	fmt.Fprintf(b, "            container: %s\n", r.ContainerName)
+	fmt.Fprintf(b, "            resource: %s\n", resource)
+	fmt.Fprintf(b, "          annotations:\n")
+	fmt.Fprintf(b, "            summary: The VPA %s target for %s/%s container %s differs from its requests by more than %.0f%%\n",
+		resource, r.ResourceType, r.ResourceName, r.ContainerName, threshold*100)
+}
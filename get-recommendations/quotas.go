@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strconv"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"get-recommendations/collector"
+)
+
+const quotaReportFile = "quota-report.csv"
+
+// namespaceTotals sums the requests across every pod of the VPA targeted workloads in a namespace, before and after the
+// recommendations are applied. Containers without a recommendation keep their current request in the recommended totals, and
+// are counted so that the check can be flagged as incomplete.
+type namespaceTotals struct {
+	cpu        int64 // millicores
+	mem        int64 // bytes
+	currentCPU int64 // millicores
+	currentMem int64 // bytes
+	pendingCPU int   // containers without a CPU recommendation
+	pendingMem int   // containers without a memory recommendation
+}
+
+type quotaCheck struct {
+	namespace   string
+	quotaName   string
+	resource    v1.ResourceName
+	hard        resource.Quantity
+	recommended resource.Quantity // of the VPA targeted workloads
+	used        resource.Quantity // by everything in the namespace, from the quota's status
+	projected   resource.Quantity // used once the recommendations are applied
+	pending     int               // containers without a recommendation for the resource
+	exceeded    bool
+}
+
+// recommendedTotals sums the recommended and current requests per namespace, multiplied by the number of replicas of each
+// workload.
+func recommendedTotals(results []collector.ContainerConfig) map[string]namespaceTotals {
+	totals := make(map[string]namespaceTotals)
+	for _, r := range results {
+		t := totals[r.Namespace]
+		replicas := int64(r.CurrentConfig.Replicas)
+		t.currentCPU += r.CurrentConfig.RequestCPU * replicas
+		t.currentMem += r.CurrentConfig.RequestMem * replicas
+		if r.TargetCPUStr == collector.Pending {
+			t.cpu += r.CurrentConfig.RequestCPU * replicas
+			t.pendingCPU++
+		} else {
+			t.cpu += r.TargetCPU * replicas
+		}
+		if r.TargetMemoryStr == collector.Pending {
+			t.mem += r.CurrentConfig.RequestMem * replicas
+			t.pendingMem++
+		} else {
+			t.mem += r.TargetMemory * replicas
+		}
+		totals[r.Namespace] = t
+	}
+
+	return totals
+}
+
+// checkQuotas compares the requests each namespace would use once the recommendations are applied against the hard limits of
+// its ResourceQuotas. Namespaces without a quota, or quotas without a CPU/memory request limit, are ignored.
+func checkQuotas(ctx context.Context, clientset *kubernetes.Clientset, results []collector.ContainerConfig, l *slog.Logger) error {
+	totals := recommendedTotals(results)
+
+	namespaces := make([]string, 0, len(totals))
+	for ns := range totals {
+		namespaces = append(namespaces, ns)
+	}
+	sort.Strings(namespaces)
+
+	checks := make([]quotaCheck, 0)
+	for _, namespace := range namespaces {
+		quotas, err := clientset.CoreV1().ResourceQuotas(namespace).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return &collector.APIError{Op: fmt.Sprintf("listing resource quotas in %s namespace", namespace), Err: err}
+		}
+
+		for _, c := range quotaChecks(namespace, quotas.Items, totals[namespace]) {
+			switch {
+			case c.exceeded:
+				l.Warn("Recommended requests exceed the namespace quota", "namespace", namespace, "quota", c.quotaName, "resource", c.resource, "hard", c.hard.String(), "used", c.used.String(), "projected", c.projected.String())
+			case c.pending > 0:
+				l.Warn("Containers without a recommendation are counted at their current requests against the namespace quota", "namespace", namespace, "quota", c.quotaName, "resource", c.resource, "pending", c.pending)
+			default:
+				l.Debug("Recommended requests within the namespace quota", "namespace", namespace, "quota", c.quotaName, "resource", c.resource, "hard", c.hard.String(), "used", c.used.String(), "projected", c.projected.String())
+			}
+			checks = append(checks, c)
+		}
+	}
+
+	return writeQuotaReport(checks)
+}
+
+// quotaChecks checks each CPU and memory request limit of the quotas against the usage once the recommendations are applied:
+// the quota's used requests, less the current requests of the VPA targeted workloads, plus their recommended requests. The used
+// requests include the pods without a VPA and any other consumers of the quota.
+func quotaChecks(namespace string, quotas []v1.ResourceQuota, t namespaceTotals) []quotaCheck {
+	type totals struct {
+		recommended, current resource.Quantity
+		pending              int
+	}
+	byResource := map[v1.ResourceName]totals{
+		v1.ResourceRequestsCPU:    {*resource.NewMilliQuantity(t.cpu, resource.DecimalSI), *resource.NewMilliQuantity(t.currentCPU, resource.DecimalSI), t.pendingCPU},
+		v1.ResourceCPU:            {*resource.NewMilliQuantity(t.cpu, resource.DecimalSI), *resource.NewMilliQuantity(t.currentCPU, resource.DecimalSI), t.pendingCPU},
+		v1.ResourceRequestsMemory: {*resource.NewQuantity(t.mem, resource.BinarySI), *resource.NewQuantity(t.currentMem, resource.BinarySI), t.pendingMem},
+		v1.ResourceMemory:         {*resource.NewQuantity(t.mem, resource.BinarySI), *resource.NewQuantity(t.currentMem, resource.BinarySI), t.pendingMem},
+	}
+
+	checks := make([]quotaCheck, 0)
+	for _, quota := range quotas {
+		for _, name := range []v1.ResourceName{v1.ResourceRequestsCPU, v1.ResourceCPU, v1.ResourceRequestsMemory, v1.ResourceMemory} {
+			hard, found := quota.Spec.Hard[name]
+			if !found {
+				continue
+			}
+
+			b := byResource[name]
+			// A quota the controller hasn't reconciled yet has no usage, so only the VPA targeted workloads are counted
+			used, found := quota.Status.Used[name]
+			if !found {
+				used = b.current.DeepCopy()
+			}
+			projected := used.DeepCopy()
+			projected.Sub(b.current)
+			projected.Add(b.recommended)
+
+			checks = append(checks, quotaCheck{
+				namespace:   namespace,
+				quotaName:   quota.Name,
+				resource:    name,
+				hard:        hard,
+				recommended: b.recommended,
+				used:        used,
+				projected:   projected,
+				pending:     b.pending,
+				exceeded:    projected.Cmp(hard) > 0,
+			})
+		}
+	}
+
+	return checks
+}
+
+func writeQuotaReport(checks []quotaCheck) error {
+	f, err := os.Create(quotaReportFile)
+	if err != nil {
+		return fmt.Errorf("creating quota report file: %w", err)
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	if err := w.Write([]string{"namespace", "quotaName", "resource", "Quota Hard Limit", "Recommended Total", "Exceeds Quota", "Used", "Projected Used", "Containers Without Recommendation"}); err != nil {
+		return fmt.Errorf("writing quota report to csv: %w", err)
+	}
+	for _, c := range checks {
+		if err := w.Write([]string{c.namespace, c.quotaName, string(c.resource), c.hard.String(), c.recommended.String(), fmt.Sprintf("%t", c.exceeded), c.used.String(), c.projected.String(), strconv.Itoa(c.pending)}); err != nil {
+			return fmt.Errorf("writing quota report to csv: %w", err)
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return fmt.Errorf("flushing csv writer: %w", err)
+	}
+
+	return nil
+}
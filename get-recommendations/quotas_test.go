@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"get-recommendations/collector"
+)
+
+func TestQuotaChecksIncludeExistingUsage(t *testing.T) {
+	results := []collector.ContainerConfig{
+		{
+			Namespace:       "team",
+			TargetCPUStr:    "300m",
+			TargetCPU:       300,
+			TargetMemoryStr: "256Mi",
+			TargetMemory:    256 * collector.Mebibyte,
+			CurrentConfig:   collector.ResourceDrift{RequestCPU: 200, RequestMem: 256 * collector.Mebibyte, Replicas: 2},
+		},
+		{
+			Namespace:       "team",
+			TargetCPUStr:    collector.Pending,
+			TargetMemoryStr: collector.Pending,
+			CurrentConfig:   collector.ResourceDrift{RequestCPU: 100, RequestMem: 128 * collector.Mebibyte, Replicas: 1},
+		},
+	}
+
+	// The VPA targeted pods use 500m of the 1800m used, the rest by pods without a VPA
+	quota := v1.ResourceQuota{
+		ObjectMeta: metav1.ObjectMeta{Name: "compute"},
+		Spec:       v1.ResourceQuotaSpec{Hard: v1.ResourceList{v1.ResourceRequestsCPU: resource.MustParse("2")}},
+		Status:     v1.ResourceQuotaStatus{Used: v1.ResourceList{v1.ResourceRequestsCPU: resource.MustParse("1800m")}},
+	}
+
+	checks := quotaChecks("team", []v1.ResourceQuota{quota}, recommendedTotals(results)["team"])
+	if len(checks) != 1 {
+		t.Fatalf("quotaChecks() returned %d checks, want 1", len(checks))
+	}
+	c := checks[0]
+
+	// 1800m - 500m current + 700m recommended, with the pending container kept at its 100m
+	if want := resource.MustParse("2"); c.projected.Cmp(want) != 0 {
+		t.Errorf("projected = %s, want %s", c.projected.String(), want.String())
+	}
+	if c.exceeded {
+		t.Errorf("exceeded = true, want false at the hard limit")
+	}
+	if c.pending != 1 {
+		t.Errorf("pending = %d, want 1", c.pending)
+	}
+
+	// The recommendations alone are well within the quota, so only the existing usage tips it over
+	quota.Spec.Hard[v1.ResourceRequestsCPU] = resource.MustParse("1900m")
+	if c := quotaChecks("team", []v1.ResourceQuota{quota}, recommendedTotals(results)["team"])[0]; !c.exceeded {
+		t.Errorf("exceeded = false with a projected %s over a hard limit of 1900m", c.projected.String())
+	}
+}
@@ -0,0 +1,27 @@
+package main
+
+import "get-recommendations/collector"
+
+// withoutRequests returns the results for containers missing a CPU or memory request, i.e. those reported as NOT_SET.
+func withoutRequests(results []collector.ContainerConfig) []collector.ContainerConfig {
+	filtered := make([]collector.ContainerConfig, 0)
+	for _, r := range results {
+		if r.CurrentConfig.CurrentCPUStr == collector.NotSet || r.CurrentConfig.CurrentMemStr == collector.NotSet {
+			filtered = append(filtered, r)
+		}
+	}
+
+	return filtered
+}
+
+// countRecommendations returns the number of results with at least one recommended target, ignoring -include-pending placeholders.
+func countRecommendations(results []collector.ContainerConfig) int {
+	count := 0
+	for _, r := range results {
+		if r.TargetCPUStr != collector.Pending || r.TargetMemoryStr != collector.Pending {
+			count++
+		}
+	}
+
+	return count
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+
+	"get-recommendations/collector"
+)
+
+const skippedReportFile = "skipped.csv"
+
+// missingTargetsFile lists the VPAs whose target doesn't exist in the VPA's namespace, e.g. left behind after the workload was
+// deleted, to help clean them up
+const missingTargetsFile = "missing-targets.csv"
+
+// writeSkippedReport writes a row per skipped VPA to skippedReportFile.
+func writeSkippedReport(skipped []collector.SkippedVPA) error {
+	f, err := os.Create(skippedReportFile)
+	if err != nil {
+		return fmt.Errorf("creating skipped report file: %w", err)
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	if err := w.Write([]string{"namespace", "vpaName", "resourceType", "resourceName", "reason"}); err != nil {
+		return fmt.Errorf("writing skipped report to csv: %w", err)
+	}
+	for _, s := range skipped {
+		if err := w.Write([]string{s.Namespace, s.VPAName, s.ResourceType, s.ResourceName, s.Reason}); err != nil {
+			return fmt.Errorf("writing skipped report to csv: %w", err)
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return fmt.Errorf("flushing csv writer: %w", err)
+	}
+
+	return nil
+}
+
+// writeMissingTargetsReport writes a row to missingTargetsFile for each skipped VPA whose target doesn't exist.
+func writeMissingTargetsReport(skipped []collector.SkippedVPA) error {
+	f, err := os.Create(missingTargetsFile)
+	if err != nil {
+		return fmt.Errorf("creating missing targets report file: %w", err)
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	if err := w.Write([]string{"namespace", "vpaName", "vpaAge", "targetAPIVersion", "targetKind", "targetName"}); err != nil {
+		return fmt.Errorf("writing missing targets report to csv: %w", err)
+	}
+	for _, s := range skipped {
+		if s.Reason != collector.SkipTargetNotFound {
+			continue
+		}
+		if err := w.Write([]string{s.Namespace, s.VPAName, formatAge(s.VPACreatedAt), s.APIVersion, s.ResourceType, s.ResourceName}); err != nil {
+			return fmt.Errorf("writing missing targets report to csv: %w", err)
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return fmt.Errorf("flushing csv writer: %w", err)
+	}
+
+	return nil
+}
+
+// countSkipped returns the number of VPAs skipped for the reason.
+func countSkipped(skipped []collector.SkippedVPA, reason string) int {
+	count := 0
+	for _, s := range skipped {
+		if s.Reason == reason {
+			count++
+		}
+	}
+
+	return count
+}
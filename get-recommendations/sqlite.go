@@ -0,0 +1,109 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"get-recommendations/collector"
+)
+
+// sqliteDriver is the database/sql driver name registered by modernc.org/sqlite, a pure Go (cgo free) SQLite driver.
+// It's only linked in when built with -tags sqlite, which also registers -output-sqlite, see sqlite_driver.go.
+const sqliteDriver = "sqlite"
+
+// sqliteTable holds one row per result, across every run written to the database
+const sqliteTable = "recommendations"
+
+// writeSQLite appends the results to the recommendations table in the SQLite database at path, creating both if required.
+// Every column is stored as text alongside the run timestamp, regardless of -output-fields, so that rows from different runs
+// can be queried together. Columns added in later versions are added to an existing table.
+func writeSQLite(results []collector.ContainerConfig, path string, runAt time.Time) error {
+	db, err := sql.Open(sqliteDriver, path)
+	if err != nil {
+		return fmt.Errorf("opening sqlite database %s: %w", path, err)
+	}
+	defer db.Close()
+
+	names := []string{"runAt"}
+	for _, c := range columns {
+		names = append(names, c.key)
+	}
+
+	definitions := make([]string, 0, len(names))
+	for _, name := range names {
+		definitions = append(definitions, fmt.Sprintf("%q TEXT", name))
+	}
+	_, err = db.Exec(fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s)", sqliteTable, strings.Join(definitions, ", ")))
+	if err != nil {
+		return fmt.Errorf("creating %s table: %w", sqliteTable, err)
+	}
+
+	existing, err := sqliteColumns(db)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if !slices.Contains(existing, name) {
+			_, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %q TEXT", sqliteTable, name))
+			if err != nil {
+				return fmt.Errorf("adding column %s to %s table: %w", name, sqliteTable, err)
+			}
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("starting sqlite transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	quoted := make([]string, 0, len(names))
+	for _, name := range names {
+		quoted = append(quoted, fmt.Sprintf("%q", name))
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(names)), ", ")
+	stmt, err := tx.Prepare(fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", sqliteTable, strings.Join(quoted, ", "), placeholders))
+	if err != nil {
+		return fmt.Errorf("preparing sqlite insert: %w", err)
+	}
+	defer stmt.Close()
+
+	for _, r := range results {
+		values := []any{runAt.UTC().Format(time.RFC3339)}
+		for _, c := range columns {
+			values = append(values, c.value(r))
+		}
+		if _, err := stmt.Exec(values...); err != nil {
+			return fmt.Errorf("inserting result into sqlite: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("committing sqlite transaction: %w", err)
+	}
+
+	return nil
+}
+
+// sqliteColumns returns the names of the columns in the recommendations table.
+func sqliteColumns(db *sql.DB) ([]string, error) {
+	rows, err := db.Query(fmt.Sprintf("SELECT name FROM pragma_table_info('%s')", sqliteTable))
+	if err != nil {
+		return nil, fmt.Errorf("querying %s table columns: %w", sqliteTable, err)
+	}
+	defer rows.Close()
+
+	names := make([]string, 0)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("reading %s table columns: %w", sqliteTable, err)
+		}
+		names = append(names, name)
+	}
+
+	return names, rows.Err()
+}
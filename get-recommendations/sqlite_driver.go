@@ -0,0 +1,17 @@
+//go:build sqlite
+
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	// Registers the pure Go SQLite driver used by -output-sqlite
+	_ "modernc.org/sqlite"
+)
+
+// registerSQLiteFlag registers -output-sqlite. The driver is kept behind a build tag as it adds significantly to the binary
+// size and build time, so the flag is only offered by binaries which have it.
+func registerSQLiteFlag(opts *options) {
+	flag.StringVar(&opts.outputSQLite, "output-sqlite", "", fmt.Sprintf("optional SQLite database file to append the results to, in a %s table alongside the run timestamp", sqliteTable))
+}
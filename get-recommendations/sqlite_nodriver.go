@@ -0,0 +1,6 @@
+//go:build !sqlite
+
+package main
+
+// registerSQLiteFlag leaves -output-sqlite unregistered, as binaries built without -tags sqlite don't have the SQLite driver.
+func registerSQLiteFlag(*options) {}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"text/tabwriter"
+	"time"
+
+	"get-recommendations/collector"
+)
+
+// summaryFile holds the aggregate metrics of each run, for dashboards which don't want to parse the full results
+const summaryFile = "summary.json"
+
+// ANSI colors applied to the drift figures in the summary. All the same length so that the tabwriter columns stay aligned
+const (
+	colorRed    = "\x1b[31m"
+	colorYellow = "\x1b[33m"
+	colorGreen  = "\x1b[32m"
+	colorGrey   = "\x1b[90m"
+	colorReset  = "\x1b[0m"
+)
+
+// Relative drift thresholds for the summary colors. Within inRangeDrift is green, beyond largeDrift is red and between is yellow
+const (
+	inRangeDrift = 0.2
+	largeDrift   = 0.5
+)
+
+// isTerminal returns true if f is a terminal rather than a pipe or file.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// writeSummary writes a table of the CPU and memory drift of each result, relative to the current requests.
+// When color is set the drift figures are colored by how far out of range they are.
+func writeSummary(w io.Writer, results []collector.ContainerConfig, color bool) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAMESPACE\tWORKLOAD\tCONTAINER\tCPU DRIFT\tMEMORY DRIFT")
+	for _, r := range results {
+		fmt.Fprintf(tw, "%s\t%s/%s\t%s\t%s\t%s\n", r.Namespace, r.ResourceType, r.ResourceName, r.ContainerName,
+			formatDrift(r.CurrentConfig.CPUDiff, r.CurrentConfig.CurrentCPU, color),
+			formatDrift(r.CurrentConfig.MemDiff, r.CurrentConfig.CurrentMem, color))
+	}
+
+	if err := tw.Flush(); err != nil {
+		return fmt.Errorf("writing summary: %w", err)
+	}
+
+	return nil
+}
+
+// formatDrift formats the diff as a signed percentage of the current value, or n/a if there isn't a current value.
+func formatDrift(diff, current int64, color bool) string {
+	drift, ok := relativeDrift(diff, current)
+	s := "n/a"
+	if ok {
+		s = fmt.Sprintf("%+.0f%%", float64(diff)/float64(current)*100)
+	}
+	if !color {
+		return s
+	}
+
+	c := colorGrey
+	switch {
+	case !ok:
+	case drift > largeDrift:
+		c = colorRed
+	case drift > inRangeDrift:
+		c = colorYellow
+	default:
+		c = colorGreen
+	}
+
+	return c + s + colorReset
+}
+
+// kindStats summarises the results for a workload kind.
+type kindStats struct {
+	workloads  map[string]bool // namespace/name of each workload of this kind
+	drifted    int             // results with a current value to compare against
+	totalDrift float64
+	containers int
+}
+
+// kindStatsOf groups the results by workload kind.
+func kindStatsOf(results []collector.ContainerConfig) map[string]*kindStats {
+	stats := make(map[string]*kindStats)
+	for _, r := range results {
+		s, found := stats[r.ResourceType]
+		if !found {
+			s = &kindStats{workloads: make(map[string]bool)}
+			stats[r.ResourceType] = s
+		}
+		s.workloads[fmt.Sprintf("%s/%s", r.Namespace, r.ResourceName)] = true
+		s.containers++
+
+		if r.CurrentConfig.CurrentCPU > 0 || r.CurrentConfig.CurrentMem > 0 {
+			s.drifted++
+			s.totalDrift += driftScore(r)
+		}
+	}
+
+	return stats
+}
+
+// averageDrift returns the average drift of the results with a current value to compare against, and false if there are none.
+func (s kindStats) averageDrift() (float64, bool) {
+	if s.drifted == 0 {
+		return 0, false
+	}
+
+	return s.totalDrift / float64(s.drifted), true
+}
+
+// logKindStats logs the number of workloads analysed per kind, and their average drift, to show where the largest rightsizing
+// opportunities are. The drift is the larger of the CPU and memory diffs relative to the current requests, see driftScore.
+func logKindStats(results []collector.ContainerConfig, l *slog.Logger) {
+	stats := kindStatsOf(results)
+
+	kinds := make([]string, 0, len(stats))
+	for kind := range stats {
+		kinds = append(kinds, kind)
+	}
+	slices.Sort(kinds)
+
+	for _, kind := range kinds {
+		s := stats[kind]
+		averageDrift := "n/a"
+		if drift, ok := s.averageDrift(); ok {
+			averageDrift = fmt.Sprintf("%.0f%%", drift*100)
+		}
+		l.Info("Workloads analysed", "resourceType", kind, "count", len(s.workloads), "averageDrift", averageDrift)
+	}
+}
+
+// runSummary is written to summaryFile each run.
+type runSummary struct {
+	SchemaVersion     int                    `json:"schemaVersion"`
+	RunAt             time.Time              `json:"runAt"`
+	Namespaces        int                    `json:"namespaces"`        // scanned
+	NamespacesSkipped []string               `json:"namespacesSkipped"` // passed via -namespaces or -namespaces-file, but don't exist
+	Workloads         int                    `json:"workloads"`
+	Containers        int                    `json:"containers"`
+	Recommendations   int                    `json:"recommendations"` // containers with a recommendation, excluding -include-pending placeholders
+	Savings           resourceTotals         `json:"savings"`         // summed decreases, multiplied by replicas
+	Increases         resourceTotals         `json:"increases"`       // summed increases, multiplied by replicas
+	Kinds             map[string]kindSummary `json:"kinds"`
+	SkippedVPAs       map[string]int         `json:"skippedVPAs"` // by reason
+	Errors            []string               `json:"errors"`      // non-fatal errors, such as a check which couldn't be run
+}
+
+type resourceTotals struct {
+	CPUMillicores int64 `json:"cpuMillicores"`
+	MemoryBytes   int64 `json:"memoryBytes"`
+}
+
+type kindSummary struct {
+	Workloads    int      `json:"workloads"`
+	Containers   int      `json:"containers"`
+	AverageDrift *float64 `json:"averageDrift"` // null if none of the containers have a current value to compare against
+}
+
+// newRunSummary aggregates the results and skipped VPAs of a run across the scanned namespaces.
+func newRunSummary(results []collector.ContainerConfig, skipped []collector.SkippedVPA, namespaces, missingNamespaces []string, errs []error) runSummary {
+	s := runSummary{
+		SchemaVersion:     schemaVersion,
+		Namespaces:        len(namespaces),
+		NamespacesSkipped: missingNamespaces,
+		Containers:        len(results),
+		Recommendations:   countRecommendations(results),
+		Kinds:             make(map[string]kindSummary),
+		SkippedVPAs:       make(map[string]int),
+		Errors:            make([]string, 0, len(errs)),
+	}
+
+	for _, r := range results {
+		replicas := int64(r.CurrentConfig.Replicas)
+		if r.CurrentConfig.CPUDiff < 0 {
+			s.Savings.CPUMillicores -= r.CurrentConfig.CPUDiff * replicas
+		} else {
+			s.Increases.CPUMillicores += r.CurrentConfig.CPUDiff * replicas
+		}
+		if r.CurrentConfig.MemDiff < 0 {
+			s.Savings.MemoryBytes -= r.CurrentConfig.MemDiff * replicas
+		} else {
+			s.Increases.MemoryBytes += r.CurrentConfig.MemDiff * replicas
+		}
+	}
+
+	for kind, stats := range kindStatsOf(results) {
+		k := kindSummary{Workloads: len(stats.workloads), Containers: stats.containers}
+		if drift, ok := stats.averageDrift(); ok {
+			k.AverageDrift = &drift
+		}
+		s.Kinds[kind] = k
+		s.Workloads += k.Workloads
+	}
+
+	// Encoded as an empty list rather than null
+	if s.NamespacesSkipped == nil {
+		s.NamespacesSkipped = make([]string, 0)
+	}
+
+	for _, v := range skipped {
+		s.SkippedVPAs[v.Reason]++
+	}
+
+	for _, err := range errs {
+		s.Errors = append(s.Errors, err.Error())
+	}
+
+	return s
+}
+
+// writeRunSummary writes the summary, stamped with the run time, to summaryFile.
+func writeRunSummary(s runSummary, runAt time.Time) error {
+	s.RunAt = runAt.UTC()
+
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding run summary: %w", err)
+	}
+
+	if err := os.WriteFile(summaryFile, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("writing run summary file: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	verticalAutoscaling "k8s.io/autoscaler/vertical-pod-autoscaler/pkg/apis/autoscaling.k8s.io/v1"
+	verticalAutoscalingClientSet "k8s.io/autoscaler/vertical-pod-autoscaler/pkg/client/clientset/versioned"
+	"k8s.io/client-go/kubernetes"
+
+	"get-recommendations/collector"
+)
+
+// unsupportedTargetsFile lists the VPAs targeting a kind whose current config can't be read, written with
+// -list-unsupported-targets
+const unsupportedTargetsFile = "unsupported-targets.csv"
+
+// writeUnsupportedTargetsReport writes a row to unsupportedTargetsFile for each VPA in the namespaces whose target isn't one
+// of the collector.SupportedKinds, e.g. a custom resource, so they can be supported or cleaned up. The namespaces are selected as for
+// a run. Returns the number of VPAs listed.
+func writeUnsupportedTargetsReport(ctx context.Context, clientset *kubernetes.Clientset, vpaClient *verticalAutoscalingClientSet.Clientset, opts options, l *slog.Logger) (int, error) {
+	namespaces := opts.Namespaces
+	if len(namespaces) == 0 {
+		var err error
+		namespaces, err = collector.GetNamespaces(ctx, clientset, opts.IncludeSystemNamespaces, opts.IncludeTerminating)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	// Listing across every namespace in one call saves a round trip per namespace, as for a run
+	var clusterVPAs map[string][]verticalAutoscaling.VerticalPodAutoscaler
+	if opts.ClusterList && len(opts.Namespaces) == 0 {
+		var err error
+		clusterVPAs, err = collector.ListClusterVPAs(ctx, vpaClient)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	byNamespace := make(map[string][]verticalAutoscaling.VerticalPodAutoscaler, len(namespaces))
+	for _, namespace := range namespaces {
+		if clusterVPAs != nil {
+			byNamespace[namespace] = clusterVPAs[namespace]
+			continue
+		}
+		list, err := vpaClient.AutoscalingV1().VerticalPodAutoscalers(namespace).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return 0, &collector.APIError{Op: fmt.Sprintf("listing VPAs in %s namespace", namespace), Err: err}
+		}
+		byNamespace[namespace] = list.Items
+	}
+
+	f, err := os.Create(unsupportedTargetsFile)
+	if err != nil {
+		return 0, fmt.Errorf("creating unsupported targets report file: %w", err)
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	if err := w.Write([]string{"namespace", "vpaName", "vpaAge", "targetAPIVersion", "targetKind", "targetName"}); err != nil {
+		return 0, fmt.Errorf("writing unsupported targets report to csv: %w", err)
+	}
+
+	count := 0
+	for _, namespace := range namespaces {
+		for _, vpa := range byNamespace[namespace] {
+			ref := vpa.Spec.TargetRef
+			if ref == nil || slices.Contains(collector.SupportedKinds, ref.Kind) {
+				continue
+			}
+
+			count++
+			l.Debug("VPA targets an unsupported kind", "namespace", namespace, "vpa", vpa.Name, "resourceType", ref.Kind, "resourceName", ref.Name)
+			if err := w.Write([]string{namespace, vpa.Name, formatAge(vpa.CreationTimestamp.Time), ref.APIVersion, ref.Kind, ref.Name}); err != nil {
+				return count, fmt.Errorf("writing unsupported targets report to csv: %w", err)
+			}
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return count, fmt.Errorf("flushing csv writer: %w", err)
+	}
+
+	return count, nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// parseS3URL splits an s3://bucket/key URL into its bucket and key.
+// If the key is empty or ends with a slash then fileName is appended, so the URL can point at a "directory".
+func parseS3URL(outputURL, fileName string) (string, string, error) {
+	u, err := url.Parse(outputURL)
+	if err != nil {
+		return "", "", fmt.Errorf("parsing output URL %q: %w", outputURL, err)
+	}
+	if u.Scheme != "s3" {
+		return "", "", fmt.Errorf("unsupported output URL scheme %q: only s3:// is supported", u.Scheme)
+	}
+	if u.Host == "" {
+		return "", "", fmt.Errorf("output URL %q is missing a bucket name", outputURL)
+	}
+
+	key := strings.TrimPrefix(u.Path, "/")
+	if key == "" || strings.HasSuffix(key, "/") {
+		key = path.Join(key, fileName)
+	}
+
+	return u.Host, key, nil
+}
+
+// uploadFile uploads a local file to the s3:// output URL.
+// Credentials are resolved via the standard AWS chain (env vars, shared config, IRSA etc).
+func uploadFile(ctx context.Context, outputURL, localPath string, l *slog.Logger) error {
+	bucket, key, err := parseS3URL(outputURL, filepath.Base(localPath))
+	if err != nil {
+		return err
+	}
+
+	cfg, err := awsconfig.LoadDefaultConfig(ctx)
+	if err != nil {
+		return fmt.Errorf("loading AWS config: %w", err)
+	}
+
+	f, err := os.Open(localPath)
+	if err != nil {
+		return fmt.Errorf("opening %s for upload: %w", localPath, err)
+	}
+	defer f.Close()
+
+	_, err = s3.NewFromConfig(cfg).PutObject(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+		Body:   f,
+	})
+	if err != nil {
+		return fmt.Errorf("uploading %s to s3://%s/%s: %w", localPath, bucket, key, err)
+	}
+	l.Info("Uploaded results", "bucket", bucket, "key", key)
+
+	return nil
+}
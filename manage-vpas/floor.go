@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	verticalAutoscaling "k8s.io/autoscaler/vertical-pod-autoscaler/pkg/apis/autoscaling.k8s.io/v1"
+	verticalAutoscalingClient "k8s.io/autoscaler/vertical-pod-autoscaler/pkg/client/clientset/versioned/typed/autoscaling.k8s.io/v1"
+)
+
+// setMinAllowed sets the minAllowed of each container policy, of each VPA in the namespace created by this script, to the
+// current target recommendation. This floors the pods at the recommended level whilst still letting the VPA recommend higher.
+// Any other policy settings are kept, and VPAs which are already floored at their target are not patched.
+func setMinAllowed(namespace string, vpaClient verticalAutoscalingClient.AutoscalingV1Interface, l *slog.Logger) error {
+	vpas, err := vpaClient.VerticalPodAutoscalers(namespace).List(context.TODO(), metav1.ListOptions{LabelSelector: fmt.Sprintf("%s=%s", managedByLabel, managedByValue)})
+	if err != nil {
+		return fmt.Errorf("error listing VPAs in %s namespace: %w", namespace, err)
+	}
+
+	for _, vpa := range vpas.Items {
+		if vpa.Status.Recommendation == nil || len(vpa.Status.Recommendation.ContainerRecommendations) == 0 {
+			l.Info("No recommendations yet. Skipping", "namespace", namespace, "vpaName", vpa.Name)
+			continue
+		}
+
+		policies := flooredPolicies(vpa)
+		if vpa.Spec.ResourcePolicy != nil && equality.Semantic.DeepEqual(vpa.Spec.ResourcePolicy.ContainerPolicies, policies) {
+			l.Debug("VPA already floored at its recommendation. Skipping", "namespace", namespace, "vpaName", vpa.Name)
+			continue
+		}
+
+		patch, err := json.Marshal(map[string]any{
+			"spec": map[string]any{
+				"resourcePolicy": map[string]any{"containerPolicies": policies},
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("error building resource policy patch for %s: %w", vpa.Name, err)
+		}
+
+		_, err = vpaClient.VerticalPodAutoscalers(namespace).Patch(context.TODO(), vpa.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+		if err != nil {
+			return fmt.Errorf("error patching resource policy of VPA %s/%s: %w", namespace, vpa.Name, err)
+		}
+		l.Info("Set VPA minAllowed to its recommendation", "namespace", namespace, "vpaName", vpa.Name)
+	}
+
+	return nil
+}
+
+// flooredPolicies returns the VPA's container policies with minAllowed set to the target recommendation of each container.
+// Policies for containers without a recommendation are unchanged.
+func flooredPolicies(vpa verticalAutoscaling.VerticalPodAutoscaler) []verticalAutoscaling.ContainerResourcePolicy {
+	policies := make([]verticalAutoscaling.ContainerResourcePolicy, 0)
+	if vpa.Spec.ResourcePolicy != nil {
+		for _, p := range vpa.Spec.ResourcePolicy.ContainerPolicies {
+			policies = append(policies, *p.DeepCopy())
+		}
+	}
+
+	for _, rec := range vpa.Status.Recommendation.ContainerRecommendations {
+		minAllowed := v1.ResourceList{}
+		for _, name := range []v1.ResourceName{v1.ResourceCPU, v1.ResourceMemory} {
+			if q, found := rec.Target[name]; found {
+				minAllowed[name] = q
+			}
+		}
+
+		i := 0
+		for i < len(policies) && policies[i].ContainerName != rec.ContainerName {
+			i++
+		}
+		if i == len(policies) {
+			policies = append(policies, verticalAutoscaling.ContainerResourcePolicy{ContainerName: rec.ContainerName})
+		}
+		policies[i].MinAllowed = minAllowed
+	}
+
+	return policies
+}
@@ -6,143 +6,586 @@ package main
 
 import (
 	"context"
+	"encoding/csv"
 	"flag"
 	"fmt"
+	"io"
 	"log/slog"
 	"os"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	autoscaling "k8s.io/api/autoscaling/v1"
+	v1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
 	verticalAutoscaling "k8s.io/autoscaler/vertical-pod-autoscaler/pkg/apis/autoscaling.k8s.io/v1"
-	verticalAutoscalingClientSet "k8s.io/autoscaler/vertical-pod-autoscaler/pkg/client/clientset/versioned"
+	verticalAutoscalingClient "k8s.io/autoscaler/vertical-pod-autoscaler/pkg/client/clientset/versioned/typed/autoscaling.k8s.io/v1"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/client-go/util/homedir"
+	"k8s.io/client-go/util/retry"
 )
 
 // Random suffix applied to all created resources to avoid potential name clashes with source control managed resources
 const vpaSuffix = "8dn39"
 
+// Workloads annotated with this set to "true" never have a VPA created for them. Lets an operator who deliberately
+// deletes a VPA stop the next run from recreating it
+const skipAnnotation = "vpa-recommendations/skip"
+
+// Label applied to every VPA created by this script
+const (
+	managedByLabel = "managed-by"
+	managedByValue = "vpa-recommendations-script"
+)
+
 func main() {
-	l, err := getLogger()
+	var namespaces []string
+	n := flag.String("namespaces", "", "comma separated list of namespaces to target")
+	e := flag.String("exclude-resources", "", "comma separated list of workloads to skip, in the format kind/name or namespace/kind/name")
+	selector := flag.String("workload-selector", "", "label selector used to filter the deployments, statefulsets and daemonsets to target, e.g. tier=backend")
+	includeSystem := flag.Bool("include-system-namespaces", false, fmt.Sprintf("include the system namespaces (%s) when targeting every namespace", strings.Join(systemNamespaces, ", ")))
+	includeTerminating := flag.Bool("include-terminating-namespaces", false, "include namespaces which are being deleted, whose workloads are only partially listed")
+	kubeconfigData := flag.String("kubeconfig-data", "", fmt.Sprintf("raw kubeconfig contents to use instead of ~/.kube/config. Defaults to the %s env var", kubeconfigDataEnv))
+	createDelay := flag.Duration("create-delay", 100*time.Millisecond, "delay after each VPA creation, to avoid overwhelming the API server and VPA admission webhook on large runs")
+	minAge := flag.Duration("min-workload-age", 0, "skip workloads created more recently than this, e.g. 1h, as they won't have meaningful VPA data yet. 0 disables the check")
+	diffOnlyNew := flag.Bool("diff-only-new", false, "print the workloads which don't have a VPA, as CSV to stdout, without creating any")
+	insecure := flag.Bool("insecure-skip-tls-verify", false, "don't verify the API server's certificate. Insecure, only use against lab clusters")
+	caFile := flag.String("ca-file", "", "CA bundle to verify the API server's certificate with, for kubeconfigs which don't embed the CA")
+	strict := flag.Bool("strict", false, "fail rather than skip when a workload's pod template has no containers, or a namespace passed via -namespaces or -namespaces-file doesn't exist")
+	floor := flag.Bool("set-min-allowed", false, "instead of creating VPAs, set the minAllowed of each VPA created by this script to its current target recommendation, flooring the pods at that level")
+	namespacesFile := flag.String("namespaces-file", "", "file listing namespaces to target, one per line. Blank lines and # comments are ignored. Merged with -namespaces")
+	concurrency := flag.Int("namespace-concurrency", 1, "number of namespaces to process in parallel. Each namespace is processed by a single worker")
+	only := flag.String("resource", "", "only target the single workload in the format kind/name, e.g. Deployment/api. Requires -namespaces to hold a single namespace")
+	onlyRightsizing := flag.Bool("only-needing-rightsizing", false, "only create VPAs for workloads with a container missing a CPU or memory request, or whose recommendations in -results-file drift beyond -drift-threshold")
+	resultsFile := flag.String("results-file", "", "results CSV from an earlier get-recommendations run, used by -only-needing-rightsizing")
+	driftThreshold := flag.Float64("drift-threshold", 0.2, "relative drift between the recommendations and requests beyond which -only-needing-rightsizing creates a VPA, e.g. 0.2 for 20%")
+	strictKindMatch := flag.Bool("strict-kind-match", false, fmt.Sprintf("only roll up to controller owners which are one of %s. Resources owned by any other kind, such as a CRD, have the VPA target the resource itself", strings.Join(supportedKinds, ", ")))
+	vpaAPIVersion := flag.String("vpa-api-version", "", fmt.Sprintf("group/version to create and read VPAs with, e.g. %s/v1beta2. Defaults to the version preferred by the API server", vpaGroup))
+	denylistFlag := flag.String("global-container-denylist", "", "comma separated list of container names, such as istio-proxy, whose scaling mode is set to Off in the created VPAs")
+	skipOperatorManaged := flag.Bool("skip-operator-managed", false, "skip workloads carrying any of the -operator-labels, as operators such as Strimzi manage their own requests and fight the VPA")
+	operatorLabels := flag.String("operator-labels", strings.Join(defaultOperatorLabels, ","), "comma separated list of label keys, or key=value pairs, identifying operator managed workloads for -skip-operator-managed")
+	reconcile := flag.Bool("reconcile", false, "instead of creating VPAs, list the VPAs created by this script whose target workload no longer exists, as CSV to stdout")
+	deleteOrphans := flag.Bool("delete-orphans", false, "with -reconcile, also delete the VPAs whose target workload no longer exists")
+	quiet := flag.Bool("quiet", false, "only log warnings and errors, regardless of LOG_LEVEL")
+	flag.Parse()
+
+	l, err := getLogger(*quiet)
 	if err != nil {
 		panic(err)
 	}
 
-	var namespaces []string
-	n := flag.String("namespaces", "", "comma separated list of namespaces to target")
-	flag.Parse()
 	if *n != "" {
 		namespaces = strings.Split(*n, ",")
-		l.Info("Targeting specific namespaces", "namespaces", *n)
+	}
+	if *namespacesFile != "" {
+		namespaces, err = readNamespacesFile(*namespacesFile, namespaces)
+		if err != nil {
+			panic(err.Error())
+		}
+	}
+	if len(namespaces) > 0 {
+		l.Info("Targeting specific namespaces", "namespaces", strings.Join(namespaces, ","))
 	}
 
-	config, err := clientcmd.BuildConfigFromFlags("", filepath.Join(homedir.HomeDir(), ".kube", "config"))
+	if *selector != "" {
+		_, err = labels.Parse(*selector)
+		if err != nil {
+			panic(fmt.Sprintf("invalid -workload-selector: %v", err))
+		}
+		l.Info("Targeting workloads matching selector", "workloadSelector", *selector)
+	}
+
+	excludes, err := parseExclusions("exclude-resources", *e)
 	if err != nil {
 		panic(err.Error())
 	}
+	if len(excludes) > 0 {
+		l.Info("Excluding specific resources", "excludeResources", *e)
+	}
 
-	clientset, err := kubernetes.NewForConfig(config)
+	var denylist []string
+	if *denylistFlag != "" {
+		denylist = strings.Split(*denylistFlag, ",")
+	}
+
+	if *deleteOrphans && !*reconcile {
+		panic("-delete-orphans requires -reconcile")
+	}
+
+	if *concurrency < 1 {
+		panic("-namespace-concurrency must be at least 1")
+	}
+	if *minAge < 0 {
+		panic("-min-workload-age must not be negative")
+	}
+	filters := resourceFilters{selector: *selector, excludes: excludes, minAge: *minAge, strict: *strict, strictKindMatch: *strictKindMatch}
+
+	if *skipOperatorManaged {
+		filters.operatorLabels, err = parseOperatorLabels(*operatorLabels)
+		if err != nil {
+			panic(err.Error())
+		}
+	}
+
+	if *onlyRightsizing {
+		if *driftThreshold <= 0 {
+			panic("-drift-threshold must be greater than zero")
+		}
+		filters.rightsizing, err = loadRightsizing(*resultsFile, *driftThreshold)
+		if err != nil {
+			panic(err.Error())
+		}
+		l.Info("Only targeting workloads needing rightsizing", "resultsFile", *resultsFile, "driftThreshold", *driftThreshold, "workloadsInResults", len(filters.rightsizing.drifted))
+	}
+
+	if *only != "" {
+		if len(namespaces) != 1 || strings.Count(*only, "/") != 1 {
+			panic("-resource must be in the format kind/name, with a single namespace set via -namespaces")
+		}
+		filters.only, err = parseExclusions("resource", *only)
+		if err != nil {
+			panic(err.Error())
+		}
+		l.Info("Targeting a single resource", "resource", *only, "namespace", namespaces[0])
+	}
+
+	config, err := buildConfig(*kubeconfigData)
+	if err != nil {
+		panic(err.Error())
+	}
+	err = applyTLSOptions(config, *insecure, *caFile, l)
 	if err != nil {
 		panic(err.Error())
 	}
 
-	vpaClient, err := verticalAutoscalingClientSet.NewForConfig(config)
+	clientset, err := kubernetes.NewForConfig(config)
 	if err != nil {
 		panic(err.Error())
 	}
 
-	if len(namespaces) == 0 {
-		namespaces, err = getNamespaces(clientset)
+	// Rollouts can only be looked up when Argo Rollouts is installed. Otherwise they're treated as any other unsupported kind
+	rolloutsServed, rolloutsScalable := discoverRollouts(clientset, l)
+	filters.rolloutScalable = rolloutsScalable
+	var rollouts dynamic.NamespaceableResourceInterface
+	if rolloutsServed {
+		dynamicClient, err := dynamic.NewForConfig(config)
 		if err != nil {
 			panic(err.Error())
 		}
+		rollouts = dynamicClient.Resource(rolloutResource)
 	}
 
-	for _, namespace := range namespaces {
-		l.Debug("Processing namespace", "namespace", namespace)
+	vpaClient, err := newVPAClient(config, *vpaAPIVersion, l)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	if len(namespaces) > 0 {
+		namespaces, err = validateNamespaces(clientset, namespaces, *strict, *includeTerminating, l)
+		if err != nil {
+			panic(err.Error())
+		}
+	} else {
+		namespaces, err = getNamespaces(clientset, *includeSystem, *includeTerminating)
+		if err != nil {
+			panic(err.Error())
+		}
+	}
 
-		resources, err := aggregateResourceNames(clientset, namespace, l)
+	if *reconcile {
+		count, err := reconcileOrphans(namespaces, clientset, rollouts, vpaClient, *deleteOrphans, os.Stdout, l)
 		if err != nil {
 			panic(err.Error())
 		}
+		l.Info("VPAs whose target no longer exists", "count", count, "deleted", *deleteOrphans)
+		return
+	}
 
-		for _, r := range resources {
-			// Refresh VPAs list for namespace as one may be created by createVPA. This could be more efficient.
-			vpas, err := vpaClient.AutoscalingV1().VerticalPodAutoscalers(namespace).List(context.TODO(), metav1.ListOptions{})
+	if *floor {
+		for _, namespace := range namespaces {
+			err = setMinAllowed(namespace, vpaClient, l)
 			if err != nil {
 				panic(err.Error())
 			}
-			l.Debug("Found VPAs in namespace", "numVPAs", len(vpas.Items), "namespace", namespace)
+		}
+		return
+	}
 
-			err = createVPA(namespace, r.apiGroup, r.resourceType, r.resourceName, vpas.Items, vpaClient, l)
-			if err != nil {
-				panic(err.Error())
+	// Coverage gap report of what would be created
+	var missing *missingReport
+	if *diffOnlyNew {
+		missing, err = newMissingReport(os.Stdout)
+		if err != nil {
+			panic(err.Error())
+		}
+	}
+
+	// Each namespace is handled by a single worker, so that checking for an existing VPA and creating one can't race
+	work := make(chan string)
+	errs := make(chan error, len(namespaces))
+	var wg sync.WaitGroup
+	for i := 0; i < *concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for namespace := range work {
+				if err := processNamespace(namespace, clientset, rollouts, vpaClient, filters, denylist, *createDelay, missing, l); err != nil {
+					errs <- err
+				}
 			}
+		}()
+	}
+	for _, namespace := range namespaces {
+		work <- namespace
+	}
+	close(work)
+	wg.Wait()
+	close(errs)
+	if err := <-errs; err != nil {
+		panic(err.Error())
+	}
+
+	if missing != nil {
+		count, err := missing.flush()
+		if err != nil {
+			panic(err.Error())
 		}
+		l.Info("Workloads without a VPA", "count", count)
 	}
 }
 
+// processNamespace creates a VPA for each of the namespace's workloads which doesn't already have one, with the denylisted
+// containers' scaling turned off, sleeping for createDelay after each creation. If missing is set, the workloads are instead
+// added to the report and no VPAs are created.
+func processNamespace(namespace string, clientset *kubernetes.Clientset, rollouts dynamic.NamespaceableResourceInterface, vpaClient verticalAutoscalingClient.AutoscalingV1Interface, filters resourceFilters, denylist []string, createDelay time.Duration, missing *missingReport, l *slog.Logger) error {
+	l.Debug("Processing namespace", "namespace", namespace)
+
+	resources, err := aggregateResourceNames(clientset, rollouts, namespace, filters, l)
+	if err != nil {
+		return err
+	}
+
+	for _, r := range resources {
+		// Refresh VPAs list for namespace as one may be created by createVPA. This could be more efficient.
+		vpas, err := vpaClient.VerticalPodAutoscalers(namespace).List(context.TODO(), metav1.ListOptions{})
+		if err != nil {
+			return fmt.Errorf("error listing VPAs in %s namespace: %w", namespace, err)
+		}
+		l.Debug("Found VPAs in namespace", "numVPAs", len(vpas.Items), "namespace", namespace)
+
+		if missing != nil {
+			targetRef := autoscaling.CrossVersionObjectReference{APIVersion: r.apiGroup, Kind: r.resourceType, Name: r.resourceName}
+			if found, _ := containsVPATarget(&targetRef, vpas.Items); !found {
+				if err := missing.add(namespace, r); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		created, err := createVPA(namespace, r.apiGroup, r.resourceType, r.resourceName, denylist, vpas.Items, vpaClient, l)
+		if err != nil {
+			return err
+		}
+		if created {
+			time.Sleep(createDelay)
+		}
+	}
+
+	return nil
+}
+
+// missingReport writes the workloads without a VPA as CSV. Safe for concurrent use.
+type missingReport struct {
+	mu       sync.Mutex
+	w        *csv.Writer
+	reported map[string]bool // keyed on namespace/kind/name, as child resources can share a parent
+}
+
+func newMissingReport(out io.Writer) (*missingReport, error) {
+	m := &missingReport{w: csv.NewWriter(out), reported: make(map[string]bool)}
+	if err := m.w.Write([]string{"namespace", "resourceType", "resourceName"}); err != nil {
+		return nil, fmt.Errorf("error writing missing VPAs report: %w", err)
+	}
+
+	return m, nil
+}
+
+// add reports the workload, unless it has already been reported.
+func (m *missingReport) add(namespace string, r resource) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := fmt.Sprintf("%s/%s/%s", namespace, r.resourceType, r.resourceName)
+	if m.reported[key] {
+		return nil
+	}
+	m.reported[key] = true
+
+	if err := m.w.Write([]string{namespace, r.resourceType, r.resourceName}); err != nil {
+		return fmt.Errorf("error writing missing VPAs report: %w", err)
+	}
+
+	return nil
+}
+
+// flush flushes the report, returning the number of workloads reported.
+func (m *missingReport) flush() (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.w.Flush()
+	if err := m.w.Error(); err != nil {
+		return 0, fmt.Errorf("error writing missing VPAs report: %w", err)
+	}
+
+	return len(m.reported), nil
+}
+
 type resource struct {
 	apiGroup     string
 	resourceType string
 	resourceName string
 }
 
+// exclusion identifies a workload which should not have a VPA created for it, or with -resource the only workload which should.
+// An empty namespace matches all namespaces.
+type exclusion struct {
+	namespace    string
+	resourceType string
+	resourceName string
+}
+
+type exclusions []exclusion
+
+// parseExclusions parses a comma separated list of kind/name or namespace/kind/name entries, set via the named flag.
+func parseExclusions(flagName, s string) (exclusions, error) {
+	results := make(exclusions, 0)
+	if s == "" {
+		return results, nil
+	}
+
+	for _, entry := range strings.Split(s, ",") {
+		parts := strings.Split(entry, "/")
+		switch len(parts) {
+		case 2:
+			results = append(results, exclusion{resourceType: parts[0], resourceName: parts[1]})
+		case 3:
+			results = append(results, exclusion{namespace: parts[0], resourceType: parts[1], resourceName: parts[2]})
+		default:
+			return nil, fmt.Errorf("invalid %s entry %q: expected kind/name or namespace/kind/name", flagName, entry)
+		}
+	}
+
+	return results, nil
+}
+
+// matches returns true if the resource has been excluded. Kinds are compared case-insensitively.
+func (e exclusions) matches(namespace, resourceType, resourceName string) bool {
+	for _, x := range e {
+		if x.namespace != "" && x.namespace != namespace {
+			continue
+		}
+		if strings.EqualFold(x.resourceType, resourceType) && x.resourceName == resourceName {
+			return true
+		}
+	}
+
+	return false
+}
+
+// resourceFilters controls which workloads aggregateResourceNames returns.
+type resourceFilters struct {
+	selector string        // label selector, all workloads if empty
+	excludes exclusions    // workloads to skip, by name
+	minAge   time.Duration // skip workloads created more recently than this. Zero disables the check
+	strict   bool          // error rather than skip workloads whose pod template has no containers
+	only     exclusions    // if set, only the workloads matching it, or owned by a parent matching it, are returned
+
+	// If set, only the workloads which look like they need rightsizing are returned
+	rightsizing *rightsizing
+
+	// keep the resource itself rather than rolling up to a controller owner which isn't one of the supportedKinds, e.g. a CRD
+	strictKindMatch bool
+
+	// workloads matching any of these, e.g. those created by the Strimzi operator, are skipped
+	operatorLabels []labels.Selector
+
+	// whether the VPA can target Argo Rollouts. If not, Deployments referenced by a Rollout are targeted themselves, and
+	// Rollouts with their own pod template are skipped
+	rolloutScalable bool
+}
+
+// supportedKinds are the workload kinds this script lists, and so knows how to read
+var supportedKinds = []string{"Deployment", "StatefulSet", "DaemonSet"}
+
 // aggregateResourceNames returns a slice containing deployments, statefulsets and daemonsets in a namespace, for later processing.
 // If a resource is owned by another resource (has an owner reference) the parent resource details are returned instead, as this is required by the VPA.
-func aggregateResourceNames(clientSet *kubernetes.Clientset, namespace string, l *slog.Logger) ([]resource, error) {
+// With strictKindMatch, parents which aren't one of the supportedKinds are ignored and the resource itself is returned. Argo
+// Rollouts with their own pod template, and those referencing a Deployment's via workloadRef in place of the Deployment, are
+// returned only if the VPA can target them, see discoverRollouts. rollouts is nil when Argo Rollouts isn't installed.
+// Only resources matching the label selector are returned (all if empty), and those matching excludes are skipped,
+// whether the exclusion names the resource itself or its parent. Resources younger than minAge, carrying the skip annotation,
+// managed by an operator or without any containers are also skipped, as are those which don't need rightsizing when that filter is set. With strict,
+// a resource without any containers is an error.
+func aggregateResourceNames(clientSet kubernetes.Interface, rollouts dynamic.NamespaceableResourceInterface, namespace string, filters resourceFilters, l *slog.Logger) ([]resource, error) {
 	results := make([]resource, 0)
+	listOptions := metav1.ListOptions{LabelSelector: filters.selector}
 
-	deployments, err := clientSet.AppsV1().Deployments(namespace).List(context.TODO(), metav1.ListOptions{})
+	deployments, err := clientSet.AppsV1().Deployments(namespace).List(context.TODO(), listOptions)
 	if err != nil {
 		return results, fmt.Errorf("error querying for deployents in %s namespace: %w", namespace, err)
 	}
 	l.Debug("Found deployments in namespace", "numDeployments", len(deployments.Items), "namespace", namespace)
 
-	statefulsets, err := clientSet.AppsV1().StatefulSets(namespace).List(context.TODO(), metav1.ListOptions{})
+	statefulsets, err := clientSet.AppsV1().StatefulSets(namespace).List(context.TODO(), listOptions)
 	if err != nil {
 		return results, fmt.Errorf("error querying for statefulsets in %s namespace: %w", namespace, err)
 	}
 	l.Debug("Found statefulsets in namespace", "numStatefulsets", len(statefulsets.Items), "namespace", namespace)
 
-	daemonsets, err := clientSet.AppsV1().DaemonSets(namespace).List(context.TODO(), metav1.ListOptions{})
+	daemonsets, err := clientSet.AppsV1().DaemonSets(namespace).List(context.TODO(), listOptions)
 	if err != nil {
 		return results, fmt.Errorf("error querying for daemonsets in %s namespace: %w", namespace, err)
 	}
 	l.Debug("Found daemonsets in namespace", "numDaemonsets", len(daemonsets.Items), "namespace", namespace)
 
-	for _, d := range deployments.Items {
-		// Check whether the resource is managed by a parent resource
-		if found, r := checkOwnedBy(d.ObjectMeta); found {
-			results = append(results, resource{resourceType: r.resourceType, resourceName: r.resourceName, apiGroup: r.apiGroup})
-			l.Debug("resource owned by another controller", "childResource", d.Name, "parentType", r.resourceType, "parentName", r.resourceName, "parentAPIGroup", r.apiGroup)
-			continue
+	// Listed regardless of the label selector, as a Deployment matching it may be referenced by a Rollout which doesn't
+	argoRollouts, err := listRollouts(rollouts, namespace)
+	if err != nil {
+		return results, err
+	}
+	l.Debug("Found rollouts in namespace", "numRollouts", len(argoRollouts), "namespace", namespace)
+	workloadRefs := rolloutWorkloadRefs(argoRollouts)
+
+	selector, err := labels.Parse(filters.selector)
+	if err != nil {
+		return results, fmt.Errorf("error parsing workload selector: %w", err)
+	}
+
+	// ownedBy returns the controller owner of the resource, or the Rollout referencing a Deployment via workloadRef
+	ownedBy := func(kind string, m metav1.ObjectMeta) (bool, resource) {
+		if found, r := checkOwnedBy(m); found {
+			return found, r
 		}
-		results = append(results, resource{resourceType: "Deployment", resourceName: d.Name, apiGroup: "apps/v1"})
+		r, found := workloadRefs[m.Name]
+		return found && kind == "Deployment", r
 	}
 
-	for _, s := range statefulsets.Items {
+	add := func(kind string, m metav1.ObjectMeta, spec v1.PodSpec) error {
+		if len(filters.only) > 0 {
+			_, parent := ownedBy(kind, m)
+			if !filters.only.matches(namespace, kind, m.Name) && !filters.only.matches(namespace, parent.resourceType, parent.resourceName) {
+				return nil
+			}
+		}
+
+		if filters.excludes.matches(namespace, kind, m.Name) {
+			l.Info("Resource excluded. Skipping", "namespace", namespace, "resourceType", kind, "resourceName", m.Name)
+			return nil
+		}
+
+		if m.Annotations[skipAnnotation] == "true" {
+			l.Info("Resource annotated to skip VPA creation. Skipping", "namespace", namespace, "resourceType", kind, "resourceName", m.Name, "annotation", skipAnnotation)
+			return nil
+		}
+
+		if operatorManaged(m.Labels, filters.operatorLabels) {
+			l.Info("Resource managed by an operator. Skipping", "namespace", namespace, "resourceType", kind, "resourceName", m.Name)
+			return nil
+		}
+
+		if filters.minAge > 0 {
+			if age := time.Since(m.CreationTimestamp.Time); age < filters.minAge {
+				l.Info("Resource younger than minimum workload age. Skipping", "namespace", namespace, "resourceType", kind, "resourceName", m.Name, "age", age.Round(time.Second).String())
+				return nil
+			}
+		}
+
+		// The VPA would never produce a recommendation
+		if len(spec.Containers) == 0 {
+			if filters.strict {
+				return fmt.Errorf("%s %s/%s has no containers in its pod template", kind, namespace, m.Name)
+			}
+			l.Warn("Resource has no containers in its pod template. Skipping", "namespace", namespace, "resourceType", kind, "resourceName", m.Name)
+			return nil
+		}
+
 		// Check whether the resource is managed by a parent resource
-		if found, r := checkOwnedBy(s.ObjectMeta); found {
-			results = append(results, resource{resourceType: r.resourceType, resourceName: r.resourceName, apiGroup: r.apiGroup})
-			l.Debug("resource owned by another controller", "childResource", s.Name, "parentType", r.resourceType, "parentName", r.resourceName, "parentAPIGroup", r.apiGroup)
-			continue
+		target := resource{resourceType: kind, resourceName: m.Name, apiGroup: "apps/v1"}
+		if kind == rolloutKind {
+			target.apiGroup = rolloutAPIVersion
+		}
+		found, r := ownedBy(kind, m)
+		if found && isRollout(r) {
+			if filters.rolloutScalable {
+				l.Info("Resource managed by an Argo Rollout. Targeting the Rollout", "namespace", namespace, "childResource", m.Name, "rolloutName", r.resourceName)
+			} else {
+				l.Info("Resource managed by an Argo Rollout, which the VPA can't target without the scale subresource. Targeting the resource itself", "namespace", namespace, "resourceType", kind, "resourceName", m.Name, "rolloutName", r.resourceName)
+				found = false
+			}
 		}
-		results = append(results, resource{resourceType: "StatefulSet", resourceName: s.Name, apiGroup: "apps/v1"})
+		if found && filters.strictKindMatch && !slices.Contains(supportedKinds, r.resourceType) && !isRollout(r) {
+			l.Debug("resource owned by an unsupported kind. Targeting the resource itself", "namespace", namespace, "childResource", m.Name, "parentType", r.resourceType, "parentName", r.resourceName, "parentAPIGroup", r.apiGroup)
+		} else if found {
+			if filters.excludes.matches(namespace, r.resourceType, r.resourceName) {
+				l.Info("Parent resource excluded. Skipping", "namespace", namespace, "childResource", m.Name, "parentType", r.resourceType, "parentName", r.resourceName)
+				return nil
+			}
+			l.Debug("resource owned by another controller", "namespace", namespace, "childResource", m.Name, "parentType", r.resourceType, "parentName", r.resourceName, "parentAPIGroup", r.apiGroup)
+			target = r
+		}
+
+		if filters.rightsizing != nil {
+			needed, reason := filters.rightsizing.needed(namespace, target.resourceType, target.resourceName, spec)
+			if !needed {
+				l.Info("Resource doesn't look like it needs rightsizing. Skipping", "namespace", namespace, "resourceType", target.resourceType, "resourceName", target.resourceName)
+				return nil
+			}
+			l.Debug("Resource needs rightsizing", "namespace", namespace, "resourceType", target.resourceType, "resourceName", target.resourceName, "reason", reason)
+		}
+		results = append(results, target)
+
+		return nil
 	}
 
+	for _, d := range deployments.Items {
+		if err := add("Deployment", d.ObjectMeta, d.Spec.Template.Spec); err != nil {
+			return nil, err
+		}
+	}
+	for _, s := range statefulsets.Items {
+		if err := add("StatefulSet", s.ObjectMeta, s.Spec.Template.Spec); err != nil {
+			return nil, err
+		}
+	}
 	for _, d := range daemonsets.Items {
-		// Check whether the resource is managed by a parent resource
-		if found, r := checkOwnedBy(d.ObjectMeta); found {
-			results = append(results, resource{resourceType: r.resourceType, resourceName: r.resourceName, apiGroup: r.apiGroup})
-			l.Debug("resource owned by another controller", "childResource", d.Name, "parentType", r.resourceType, "parentName", r.resourceName, "parentAPIGroup", r.apiGroup)
+		if err := add("DaemonSet", d.ObjectMeta, d.Spec.Template.Spec); err != nil {
+			return nil, err
+		}
+	}
+	for _, r := range argoRollouts {
+		// Those referencing a Deployment are targeted via the Deployment above
+		if r.Spec.Template == nil || r.Spec.WorkloadRef != nil || !selector.Matches(labels.Set(r.Labels)) {
+			continue
+		}
+		if !filters.rolloutScalable {
+			l.Info("Argo Rollout can't be targeted by the VPA without the scale subresource. Skipping", "namespace", namespace, "resourceType", rolloutKind, "resourceName", r.Name)
 			continue
 		}
-		results = append(results, resource{resourceType: "DaemonSet", resourceName: d.Name, apiGroup: "apps/v1"})
+		if err := add(rolloutKind, r.ObjectMeta, r.Spec.Template.Spec); err != nil {
+			return nil, err
+		}
 	}
 
 	return results, nil
@@ -168,8 +611,11 @@ func checkOwnedBy(m metav1.ObjectMeta) (bool, resource) {
 	return false, resource{}
 }
 
-// createVPA creates a new VPA for a target object, if one does not already exist.
-func createVPA(namespace, apiGroup, resourceType, resourceName string, vpas []verticalAutoscaling.VerticalPodAutoscaler, vpaClient *verticalAutoscalingClientSet.Clientset, l *slog.Logger) error {
+// createBackoff is used to retry VPA creation when the API server responds with 429 Too Many Requests
+var createBackoff = wait.Backoff{Duration: time.Second, Factor: 2, Jitter: 0.1, Steps: 5}
+
+// createVPA creates a new VPA for a target object, if one does not already exist. Returns true if a VPA was created.
+func createVPA(namespace, apiGroup, resourceType, resourceName string, denylist []string, vpas []verticalAutoscaling.VerticalPodAutoscaler, vpaClient verticalAutoscalingClient.AutoscalingV1Interface, l *slog.Logger) (bool, error) {
 	targetRef := autoscaling.CrossVersionObjectReference{
 		APIVersion: apiGroup,
 		Kind:       resourceType,
@@ -178,8 +624,8 @@ func createVPA(namespace, apiGroup, resourceType, resourceName string, vpas []ve
 
 	// Skip if there is an existing VPA with the same config in this namespace
 	if found, existingVPAName := containsVPATarget(&targetRef, vpas); found {
-		l.Info("Found existing VPA. Skipping", "existingVPAName", existingVPAName, "resourceType", resourceType, "resourceName", resourceName)
-		return nil
+		l.Info("Found existing VPA. Skipping", "namespace", namespace, "existingVPAName", existingVPAName, "resourceType", resourceType, "resourceName", resourceName)
+		return false, nil
 	}
 
 	// Run in recommendation only mode
@@ -192,7 +638,7 @@ func createVPA(namespace, apiGroup, resourceType, resourceName string, vpas []ve
 
 			Labels: map[string]string{
 				"source-control-managed": "false",
-				"managed-by":             "vpa-recommendations-script",
+				managedByLabel:           managedByValue,
 			},
 		},
 
@@ -204,13 +650,29 @@ func createVPA(namespace, apiGroup, resourceType, resourceName string, vpas []ve
 		},
 	}
 
-	_, err := vpaClient.AutoscalingV1().VerticalPodAutoscalers(namespace).Create(context.TODO(), &vpa, metav1.CreateOptions{})
+	// Denylisted containers, such as well-known sidecars, are never rightsized so don't need recommendations
+	if len(denylist) > 0 {
+		off := verticalAutoscaling.ContainerScalingModeOff
+		policies := make([]verticalAutoscaling.ContainerResourcePolicy, 0, len(denylist))
+		for _, name := range denylist {
+			policies = append(policies, verticalAutoscaling.ContainerResourcePolicy{ContainerName: name, Mode: &off})
+		}
+		vpa.Spec.ResourcePolicy = &verticalAutoscaling.PodResourcePolicy{ContainerPolicies: policies}
+	}
+
+	err := retry.OnError(createBackoff, k8serrors.IsTooManyRequests, func() error {
+		_, err := vpaClient.VerticalPodAutoscalers(namespace).Create(context.TODO(), &vpa, metav1.CreateOptions{})
+		if k8serrors.IsTooManyRequests(err) {
+			l.Warn("Throttled whilst creating VPA. Backing off", "vpaName", vpa.Name, "namespace", namespace)
+		}
+		return err
+	})
 	if err != nil {
-		return fmt.Errorf("error creating VPA for %s/%s: %w", resourceType, resourceName, err)
+		return false, fmt.Errorf("error creating VPA for %s/%s: %w", resourceType, resourceName, err)
 	}
 	l.Info("Created VPA", "vpaName", vpa.Name, "namespace", namespace)
 
-	return nil
+	return true, nil
 }
 
 // containsVPATarget returns true, including the VPA name, if a VPA target (spec) is already defined in vpas.
@@ -229,8 +691,55 @@ func containsVPATarget(spec *autoscaling.CrossVersionObjectReference, vpas []ver
 	return found, existingVPAName
 }
 
-// getNamespaces returns all the namespaces in the cluster
-func getNamespaces(client *kubernetes.Clientset) ([]string, error) {
+// readNamespacesFile appends the namespaces listed one per line in path to namespaces, skipping duplicates.
+// Blank lines and lines starting with # are ignored.
+func readNamespacesFile(path string, namespaces []string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading namespaces file: %w", err)
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		namespace := strings.TrimSpace(line)
+		if namespace == "" || strings.HasPrefix(namespace, "#") || slices.Contains(namespaces, namespace) {
+			continue
+		}
+		namespaces = append(namespaces, namespace)
+	}
+
+	return namespaces, nil
+}
+
+// validateNamespaces returns the namespaces which exist in the cluster, warning about any which don't, e.g. due to a typo.
+// Terminating namespaces are treated as missing unless includeTerminating is set. With strict, a missing namespace is an
+// error instead.
+func validateNamespaces(client *kubernetes.Clientset, namespaces []string, strict, includeTerminating bool, l *slog.Logger) ([]string, error) {
+	existing, err := getNamespaces(client, true, includeTerminating)
+	if err != nil {
+		return nil, fmt.Errorf("error listing namespaces: %w", err)
+	}
+
+	valid := make([]string, 0, len(namespaces))
+	for _, namespace := range namespaces {
+		if slices.Contains(existing, namespace) {
+			valid = append(valid, namespace)
+			continue
+		}
+		if strict {
+			return nil, fmt.Errorf("namespace %s does not exist or is terminating", namespace)
+		}
+		l.Warn("Namespace does not exist or is terminating. Skipping", "namespace", namespace)
+	}
+
+	return valid, nil
+}
+
+// systemNamespaces are skipped when targeting every namespace, unless -include-system-namespaces is set
+var systemNamespaces = []string{"kube-system", "kube-public", "kube-node-lease"}
+
+// getNamespaces returns all the namespaces in the cluster, excluding the well-known system namespaces unless includeSystem is set.
+// Namespaces being deleted are also excluded unless includeTerminating is set, as listing their workloads gives partial results
+func getNamespaces(client *kubernetes.Clientset, includeSystem, includeTerminating bool) ([]string, error) {
 	result := make([]string, 0)
 
 	namespaces, err := client.CoreV1().Namespaces().List(context.TODO(), metav1.ListOptions{})
@@ -239,14 +748,66 @@ func getNamespaces(client *kubernetes.Clientset) ([]string, error) {
 	}
 
 	for _, ns := range namespaces.Items {
+		if !includeSystem && slices.Contains(systemNamespaces, ns.Name) {
+			continue
+		}
+		if !includeTerminating && ns.Status.Phase == v1.NamespaceTerminating {
+			continue
+		}
 		result = append(result, ns.Name)
 	}
 
 	return result, nil
 }
 
+// kubeconfigDataEnv can hold the raw kubeconfig, e.g. when it's injected from a secret in CI
+const kubeconfigDataEnv = "KUBECONFIG_DATA"
+
+// buildConfig returns the client config from the raw kubeconfig data if provided, so that it never has to be written to disk.
+// Otherwise the default kubeconfig location is used.
+func buildConfig(kubeconfigData string) (*rest.Config, error) {
+	// Read here rather than used as the flag default, so that the contents aren't printed in the -help output
+	if kubeconfigData == "" {
+		kubeconfigData = os.Getenv(kubeconfigDataEnv)
+	}
+
+	if kubeconfigData != "" {
+		config, err := clientcmd.RESTConfigFromKubeConfig([]byte(kubeconfigData))
+		if err != nil {
+			return nil, fmt.Errorf("error loading kubeconfig data: %w", err)
+		}
+		return config, nil
+	}
+
+	return clientcmd.BuildConfigFromFlags("", filepath.Join(homedir.HomeDir(), ".kube", "config"))
+}
+
+// applyTLSOptions overrides the API server TLS verification settings from the kubeconfig, for clusters with self-signed certs.
+func applyTLSOptions(config *rest.Config, insecure bool, caFile string, l *slog.Logger) error {
+	if insecure && caFile != "" {
+		return fmt.Errorf("-insecure-skip-tls-verify and -ca-file are mutually exclusive")
+	}
+
+	if caFile != "" {
+		if _, err := os.Stat(caFile); err != nil {
+			return fmt.Errorf("error reading -ca-file: %w", err)
+		}
+		// The CA data embedded in the kubeconfig takes precedence over the file, so it has to be cleared
+		config.CAFile, config.CAData = caFile, nil
+	}
+
+	if insecure {
+		l.Warn("!!! TLS VERIFICATION OF THE API SERVER IS DISABLED. The connection is vulnerable to interception. Only use against lab clusters !!!")
+		// client-go refuses to combine insecure with a root CA
+		config.Insecure, config.CAFile, config.CAData = true, "", nil
+	}
+
+	return nil
+}
+
 // getLogger creates structured logger which defaults to info level (https://pkg.go.dev/log/slog#Level).
-func getLogger() (*slog.Logger, error) {
+// If quiet is set the level is raised to at least warn, so that only problems are reported.
+func getLogger(quiet bool) (*slog.Logger, error) {
 	var logger *slog.Logger
 
 	var logLevel = os.Getenv("LOG_LEVEL")
@@ -258,6 +819,9 @@ func getLogger() (*slog.Logger, error) {
 	if err != nil {
 		return logger, fmt.Errorf("error parsing LOG_LEVEL: %w", err)
 	}
+	if quiet && slog.Level(level) < slog.LevelWarn {
+		level = int(slog.LevelWarn)
+	}
 	handler := slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.Level(level)})
 	logger = slog.New(handler)
 
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strconv"
+
+	autoscaling "k8s.io/api/autoscaling/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	verticalAutoscalingClient "k8s.io/autoscaler/vertical-pod-autoscaler/pkg/client/clientset/versioned/typed/autoscaling.k8s.io/v1"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+)
+
+// errUnsupportedKind is returned when a VPA targets a kind this script can't look up, such as a custom resource
+var errUnsupportedKind = errors.New("unsupported target kind")
+
+// targetExists returns whether the workload targeted by a VPA exists. Only the supportedKinds and Argo Rollouts can be checked,
+// returning errUnsupportedKind for any other. rollouts is nil when Argo Rollouts isn't installed, so Rollouts can't be checked.
+func targetExists(clientset *kubernetes.Clientset, rollouts dynamic.NamespaceableResourceInterface, namespace string, ref *autoscaling.CrossVersionObjectReference) (bool, error) {
+	var err error
+	switch ref.Kind {
+	case "Deployment":
+		_, err = clientset.AppsV1().Deployments(namespace).Get(context.TODO(), ref.Name, metav1.GetOptions{})
+	case "StatefulSet":
+		_, err = clientset.AppsV1().StatefulSets(namespace).Get(context.TODO(), ref.Name, metav1.GetOptions{})
+	case "DaemonSet":
+		_, err = clientset.AppsV1().DaemonSets(namespace).Get(context.TODO(), ref.Name, metav1.GetOptions{})
+	case rolloutKind:
+		if rollouts == nil || !isRollout(resource{apiGroup: ref.APIVersion, resourceType: ref.Kind}) {
+			return false, errUnsupportedKind
+		}
+		_, err = rollouts.Namespace(namespace).Get(context.TODO(), ref.Name, metav1.GetOptions{})
+	default:
+		return false, errUnsupportedKind
+	}
+
+	if k8serrors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("error getting %s %s/%s: %w", ref.Kind, namespace, ref.Name, err)
+	}
+
+	return true, nil
+}
+
+// reconcileOrphans writes a CSV row to out for each VPA created by this script whose target workload no longer exists,
+// e.g. left behind after the workload was deleted. With deleteOrphans the VPAs are also deleted. VPAs targeting a kind
+// which can't be looked up are left alone. Returns the number of orphaned VPAs found.
+func reconcileOrphans(namespaces []string, clientset *kubernetes.Clientset, rollouts dynamic.NamespaceableResourceInterface, vpaClient verticalAutoscalingClient.AutoscalingV1Interface, deleteOrphans bool, out io.Writer, l *slog.Logger) (int, error) {
+	w := csv.NewWriter(out)
+	if err := w.Write([]string{"namespace", "vpaName", "resourceType", "resourceName", "deleted"}); err != nil {
+		return 0, fmt.Errorf("error writing orphaned VPAs report: %w", err)
+	}
+
+	count := 0
+	for _, namespace := range namespaces {
+		vpas, err := vpaClient.VerticalPodAutoscalers(namespace).List(context.TODO(), metav1.ListOptions{LabelSelector: fmt.Sprintf("%s=%s", managedByLabel, managedByValue)})
+		if err != nil {
+			return count, fmt.Errorf("error listing VPAs in %s namespace: %w", namespace, err)
+		}
+
+		for _, vpa := range vpas.Items {
+			if vpa.Spec.TargetRef == nil {
+				continue
+			}
+
+			exists, err := targetExists(clientset, rollouts, namespace, vpa.Spec.TargetRef)
+			if errors.Is(err, errUnsupportedKind) {
+				l.Debug("VPA target kind can't be checked. Skipping", "namespace", namespace, "vpaName", vpa.Name, "resourceType", vpa.Spec.TargetRef.Kind, "resourceName", vpa.Spec.TargetRef.Name)
+				continue
+			}
+			if err != nil {
+				return count, err
+			}
+			if exists {
+				continue
+			}
+
+			count++
+			l.Info("VPA target no longer exists", "namespace", namespace, "vpaName", vpa.Name, "resourceType", vpa.Spec.TargetRef.Kind, "resourceName", vpa.Spec.TargetRef.Name)
+			if deleteOrphans {
+				err = vpaClient.VerticalPodAutoscalers(namespace).Delete(context.TODO(), vpa.Name, metav1.DeleteOptions{})
+				if err != nil && !k8serrors.IsNotFound(err) {
+					return count, fmt.Errorf("error deleting VPA %s/%s: %w", namespace, vpa.Name, err)
+				}
+				l.Info("Deleted orphaned VPA", "namespace", namespace, "vpaName", vpa.Name)
+			}
+
+			if err := w.Write([]string{namespace, vpa.Name, vpa.Spec.TargetRef.Kind, vpa.Spec.TargetRef.Name, strconv.FormatBool(deleteOrphans)}); err != nil {
+				return count, fmt.Errorf("error writing orphaned VPAs report: %w", err)
+			}
+		}
+	}
+
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return count, fmt.Errorf("error writing orphaned VPAs report: %w", err)
+	}
+
+	return count, nil
+}
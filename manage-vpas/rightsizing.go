@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"os"
+
+	v1 "k8s.io/api/core/v1"
+	k8sresource "k8s.io/apimachinery/pkg/api/resource"
+)
+
+// rightsizing limits VPA creation to the workloads which look like they need rightsizing: those with a container missing a
+// CPU or memory request, or whose recommendations in an earlier get-recommendations results file drift beyond the threshold.
+type rightsizing struct {
+	threshold float64
+	drifted   map[string]bool // keyed by namespace/kind/name. Whether any container drifts beyond the threshold
+}
+
+// utf8BOM starts results files written by get-recommendations with -csv-bom
+const utf8BOM = "\ufeff"
+
+// skipBOM returns a reader over r without its leading UTF-8 byte order mark, if any, as csv.Reader would otherwise read the
+// mark as part of the schema version comment and not skip it.
+func skipBOM(r io.Reader) io.Reader {
+	br := bufio.NewReader(r)
+	if b, err := br.Peek(len(utf8BOM)); err == nil && string(b) == utf8BOM {
+		br.Discard(len(utf8BOM))
+	}
+
+	return br
+}
+
+// workloadKey identifies a VPA target in the results file.
+func workloadKey(namespace, resourceType, resourceName string) string {
+	return fmt.Sprintf("%s/%s/%s", namespace, resourceType, resourceName)
+}
+
+// loadRightsizing reads the results CSV written by get-recommendations (results.csv with the default -format=csv) at path, if
+// set. Columns are located by their header, so any -output-fields order can be read. Containers whose recommendation is
+// pending, or which fail to parse, are ignored.
+func loadRightsizing(path string, threshold float64) (*rightsizing, error) {
+	r := &rightsizing{threshold: threshold, drifted: make(map[string]bool)}
+	if path == "" {
+		return r, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening results file: %w", err)
+	}
+	defer f.Close()
+
+	reader := csv.NewReader(skipBOM(f))
+	reader.Comment = '#'
+	reader.FieldsPerRecord = -1
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("error reading results file %s: %w", path, err)
+	}
+	if len(records) == 0 {
+		return r, nil
+	}
+
+	columns := make(map[string]int)
+	for i, name := range records[0] {
+		columns[name] = i
+	}
+	for _, required := range []string{"namespace", "resourceType", "resourceName", "VPA Target CPU", "VPA Target Memory", "Current CPU Requests", "Current Memory Requests"} {
+		if _, found := columns[required]; !found {
+			return nil, fmt.Errorf("results file %s is missing the %q column", path, required)
+		}
+	}
+
+	for _, record := range records[1:] {
+		key := workloadKey(record[columns["namespace"]], record[columns["resourceType"]], record[columns["resourceName"]])
+		cpu, cpuOK := quantityDrift(record[columns["VPA Target CPU"]], record[columns["Current CPU Requests"]])
+		mem, memOK := quantityDrift(record[columns["VPA Target Memory"]], record[columns["Current Memory Requests"]])
+		if !cpuOK && !memOK {
+			continue
+		}
+		r.drifted[key] = r.drifted[key] || cpu > threshold || mem > threshold
+	}
+
+	return r, nil
+}
+
+// quantityDrift returns the absolute difference between the target and current quantities, relative to the current. A current
+// value which isn't set is treated as infinite drift. False if the target isn't a quantity, e.g. PENDING.
+func quantityDrift(target, current string) (float64, bool) {
+	t, err := k8sresource.ParseQuantity(target)
+	if err != nil {
+		return 0, false
+	}
+	c, err := k8sresource.ParseQuantity(current)
+	if err != nil || c.IsZero() {
+		return math.Inf(1), true
+	}
+
+	return math.Abs(t.AsApproximateFloat64()-c.AsApproximateFloat64()) / c.AsApproximateFloat64(), true
+}
+
+// needed returns true if the workload should have a VPA created, along with the reason.
+func (r *rightsizing) needed(namespace, resourceType, resourceName string, spec v1.PodSpec) (bool, string) {
+	for _, c := range spec.Containers {
+		if c.Resources.Requests.Cpu().IsZero() || c.Resources.Requests.Memory().IsZero() {
+			return true, "container missing requests"
+		}
+	}
+
+	if r.drifted[workloadKey(namespace, resourceType, resourceName)] {
+		return true, fmt.Sprintf("recommendations drift more than %.0f%% from the requests", r.threshold*100)
+	}
+
+	return false, ""
+}
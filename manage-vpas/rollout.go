@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Argo Rollouts own the ReplicaSets of canary and blue/green releases in place of a Deployment
+const (
+	rolloutKind       = "Rollout"
+	rolloutAPIGroup   = "argoproj.io"
+	rolloutAPIVersion = rolloutAPIGroup + "/v1alpha1"
+)
+
+// isRollout returns whether the resource is an Argo Rollout.
+func isRollout(r resource) bool {
+	return r.resourceType == rolloutKind && strings.HasPrefix(r.apiGroup, rolloutAPIGroup+"/")
+}
+
+// rolloutResource is read via the dynamic client, as Argo Rollouts has no typed client in client-go
+var rolloutResource = schema.GroupVersionResource{Group: rolloutAPIGroup, Version: "v1alpha1", Resource: "rollouts"}
+
+// discoverRollouts returns whether the cluster serves Argo Rollouts, and whether their CRD has the scale subresource. The VPA
+// reads the pod selector of targets which aren't a built-in workload kind via their scale subresource, so can only target
+// Rollouts with it. Any discovery failure, such as a forbidden or unavailable aggregated API, is logged and treated as
+// Rollouts not being served, so that it doesn't stop runs in namespaces without them.
+func discoverRollouts(clientset kubernetes.Interface, l *slog.Logger) (served, scalable bool) {
+	resources, err := clientset.Discovery().ServerResourcesForGroupVersion(rolloutAPIVersion)
+	if k8serrors.IsNotFound(err) {
+		return false, false
+	}
+	if err != nil {
+		l.Warn("Unable to discover the Argo Rollouts API. Treating Rollouts as unsupported", "apiVersion", rolloutAPIVersion, "error", err)
+		return false, false
+	}
+
+	has := func(name string) bool {
+		return slices.ContainsFunc(resources.APIResources, func(r metav1.APIResource) bool { return r.Name == name })
+	}
+	return has(rolloutResource.Resource), has(rolloutResource.Resource + "/scale")
+}
+
+// argoRollout holds the fields of a Rollout read here. A Rollout either has its own pod template, or references a Deployment's
+// via workloadRef, in which case the Deployment is left scaled to zero and has no ownerReference to the Rollout.
+type argoRollout struct {
+	metav1.ObjectMeta `json:"metadata"`
+	Spec              struct {
+		Template    *v1.PodTemplateSpec `json:"template,omitempty"`
+		WorkloadRef *struct {
+			Kind string `json:"kind"`
+			Name string `json:"name"`
+		} `json:"workloadRef,omitempty"`
+	} `json:"spec"`
+}
+
+// resource returns the Rollout as a VPA target.
+func (r argoRollout) resource() resource {
+	return resource{resourceType: rolloutKind, resourceName: r.Name, apiGroup: rolloutAPIVersion}
+}
+
+// listRollouts returns the namespace's Rollouts. rollouts is nil when Argo Rollouts isn't installed, in which case there are none.
+func listRollouts(rollouts dynamic.NamespaceableResourceInterface, namespace string) ([]argoRollout, error) {
+	results := make([]argoRollout, 0)
+	if rollouts == nil {
+		return results, nil
+	}
+
+	list, err := rollouts.Namespace(namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return results, fmt.Errorf("error querying for rollouts in %s namespace: %w", namespace, err)
+	}
+
+	for _, item := range list.Items {
+		var r argoRollout
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(item.Object, &r); err != nil {
+			return results, fmt.Errorf("error reading rollout %s/%s: %w", namespace, item.GetName(), err)
+		}
+		results = append(results, r)
+	}
+
+	return results, nil
+}
+
+// rolloutWorkloadRefs returns the Rollouts referencing a Deployment's pod template via workloadRef, keyed by the Deployment's name.
+func rolloutWorkloadRefs(rollouts []argoRollout) map[string]resource {
+	refs := make(map[string]resource)
+	for _, r := range rollouts {
+		if r.Spec.WorkloadRef != nil && r.Spec.WorkloadRef.Kind == "Deployment" {
+			refs[r.Spec.WorkloadRef.Name] = r.resource()
+		}
+	}
+
+	return refs
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"slices"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func rolloutObject(name string, spec map[string]interface{}) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": rolloutAPIVersion,
+		"kind":       rolloutKind,
+		"metadata":   map[string]interface{}{"namespace": "team", "name": name},
+		"spec":       spec,
+	}}
+}
+
+func TestAggregateResourceNamesRollouts(t *testing.T) {
+	containers := []v1.Container{{Name: "app"}}
+	clientset := fake.NewSimpleClientset(
+		// Scaled to zero, with its pod template run by the "web" Rollout via workloadRef
+		&appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "team", Name: "web"},
+			Spec:       appsv1.DeploymentSpec{Template: v1.PodTemplateSpec{Spec: v1.PodSpec{Containers: containers}}},
+		},
+		&appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "team", Name: "worker"},
+			Spec:       appsv1.DeploymentSpec{Template: v1.PodTemplateSpec{Spec: v1.PodSpec{Containers: containers}}},
+		},
+	)
+	rollouts := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{rolloutResource: "RolloutList"},
+		rolloutObject("web", map[string]interface{}{
+			"workloadRef": map[string]interface{}{"apiVersion": "apps/v1", "kind": "Deployment", "name": "web"},
+		}),
+		rolloutObject("api", map[string]interface{}{
+			"template": map[string]interface{}{"spec": map[string]interface{}{
+				"containers": []interface{}{map[string]interface{}{"name": "app"}},
+			}},
+		}),
+	).Resource(rolloutResource)
+	l := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	tests := []struct {
+		name     string
+		scalable bool
+		want     []resource
+	}{
+		{
+			name:     "rollouts targeted when scalable",
+			scalable: true,
+			want: []resource{
+				{resourceType: rolloutKind, resourceName: "web", apiGroup: rolloutAPIVersion},
+				{resourceType: "Deployment", resourceName: "worker", apiGroup: "apps/v1"},
+				{resourceType: rolloutKind, resourceName: "api", apiGroup: rolloutAPIVersion},
+			},
+		},
+		{
+			name:     "referenced deployment targeted when rollouts aren't scalable",
+			scalable: false,
+			want: []resource{
+				{resourceType: "Deployment", resourceName: "web", apiGroup: "apps/v1"},
+				{resourceType: "Deployment", resourceName: "worker", apiGroup: "apps/v1"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := aggregateResourceNames(clientset, rollouts, "team", resourceFilters{rolloutScalable: tt.scalable}, l)
+			if err != nil {
+				t.Fatalf("aggregateResourceNames() error = %v", err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("aggregateResourceNames() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// forbiddenDiscovery fails discovery as an RBAC denied aggregated API would
+type forbiddenDiscovery struct {
+	*fakediscovery.FakeDiscovery
+}
+
+func (forbiddenDiscovery) ServerResourcesForGroupVersion(groupVersion string) (*metav1.APIResourceList, error) {
+	return nil, k8serrors.NewForbidden(schema.GroupResource{Group: rolloutAPIGroup}, "", nil)
+}
+
+type forbiddenDiscoveryClientset struct {
+	*fake.Clientset
+}
+
+func (c forbiddenDiscoveryClientset) Discovery() discovery.DiscoveryInterface {
+	return forbiddenDiscovery{c.Clientset.Discovery().(*fakediscovery.FakeDiscovery)}
+}
+
+func TestDiscoverRollouts(t *testing.T) {
+	scalable := fake.NewSimpleClientset()
+	scalable.Resources = []*metav1.APIResourceList{{
+		GroupVersion: rolloutAPIVersion,
+		APIResources: []metav1.APIResource{{Name: "rollouts"}, {Name: "rollouts/scale"}},
+	}}
+	l := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	tests := []struct {
+		name         string
+		clientset    kubernetes.Interface
+		wantServed   bool
+		wantScalable bool
+	}{
+		{name: "served with scale subresource", clientset: scalable, wantServed: true, wantScalable: true},
+		{name: "not installed", clientset: fake.NewSimpleClientset()},
+		{name: "discovery forbidden", clientset: forbiddenDiscoveryClientset{fake.NewSimpleClientset()}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			served, scalable := discoverRollouts(tt.clientset, l)
+			if served != tt.wantServed || scalable != tt.wantScalable {
+				t.Errorf("discoverRollouts() = %t, %t, want %t, %t", served, scalable, tt.wantServed, tt.wantScalable)
+			}
+		})
+	}
+}
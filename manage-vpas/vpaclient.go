@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	verticalAutoscaling "k8s.io/autoscaler/vertical-pod-autoscaler/pkg/apis/autoscaling.k8s.io/v1"
+	verticalAutoscalingClient "k8s.io/autoscaler/vertical-pod-autoscaler/pkg/client/clientset/versioned/typed/autoscaling.k8s.io/v1"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/rest"
+)
+
+// vpaGroup is the API group the VPA CRDs are served under
+const vpaGroup = "autoscaling.k8s.io"
+
+// newVPAClient returns a VPA client which talks to the API server at the given group/version, e.g. autoscaling.k8s.io/v1beta2.
+// If apiVersion is empty, the version the API server prefers for the VPA group is discovered.
+//
+// The VPA types are the same across the served versions for the fields used here, so the v1 types are registered under
+// the chosen version rather than needing a client per version.
+func newVPAClient(config *rest.Config, apiVersion string, l *slog.Logger) (verticalAutoscalingClient.AutoscalingV1Interface, error) {
+	if apiVersion == "" {
+		var err error
+		apiVersion, err = preferredVPAVersion(config)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	gv, err := schema.ParseGroupVersion(apiVersion)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing VPA API version %q: %w", apiVersion, err)
+	}
+	if gv.Group != vpaGroup {
+		return nil, fmt.Errorf("VPA API version %q must be in the %s group", apiVersion, vpaGroup)
+	}
+	l.Info("Using VPA API version", "apiVersion", gv.String())
+
+	scheme := runtime.NewScheme()
+	scheme.AddKnownTypes(gv, &verticalAutoscaling.VerticalPodAutoscaler{}, &verticalAutoscaling.VerticalPodAutoscalerList{})
+	metav1.AddToGroupVersion(scheme, gv)
+
+	c := rest.CopyConfig(config)
+	c.GroupVersion = &gv
+	c.APIPath = "/apis"
+	c.NegotiatedSerializer = serializer.NewCodecFactory(scheme).WithoutConversion()
+	if c.UserAgent == "" {
+		c.UserAgent = rest.DefaultKubernetesUserAgent()
+	}
+
+	client, err := rest.RESTClientFor(c)
+	if err != nil {
+		return nil, fmt.Errorf("error creating VPA client: %w", err)
+	}
+
+	return verticalAutoscalingClient.New(client), nil
+}
+
+// preferredVPAVersion returns the group/version the API server prefers for the VPA group.
+func preferredVPAVersion(config *rest.Config) (string, error) {
+	dc, err := discovery.NewDiscoveryClientForConfig(config)
+	if err != nil {
+		return "", fmt.Errorf("error creating discovery client: %w", err)
+	}
+
+	groups, err := dc.ServerGroups()
+	if err != nil {
+		return "", fmt.Errorf("error discovering API groups: %w", err)
+	}
+	for _, g := range groups.Groups {
+		if g.Name == vpaGroup {
+			return g.PreferredVersion.GroupVersion, nil
+		}
+	}
+
+	return "", fmt.Errorf("the %s API group isn't served. Are the VPA CRDs installed?", vpaGroup)
+}